@@ -4,16 +4,17 @@ go 1.24.2
 
 require (
 	github.com/fatih/color v1.16.0
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a
 	github.com/jackc/pgx/v5 v5.5.0
+	github.com/mattn/go-isatty v0.0.20
 	github.com/spf13/cobra v1.8.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
-	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/crypto v0.9.0 // indirect
 	golang.org/x/sys v0.14.0 // indirect
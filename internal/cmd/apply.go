@@ -1,9 +1,13 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/matroidbe/pgmigrate/internal/db"
 	"github.com/matroidbe/pgmigrate/internal/output"
 	"github.com/spf13/cobra"
@@ -12,6 +16,21 @@ import (
 var (
 	allowDestructive bool
 	autoApprove      bool
+	applyFlat        bool
+	applyEvents      string
+
+	lockTimeout      time.Duration
+	statementTimeout time.Duration
+	applyRetries     int
+	retryBackoff     time.Duration
+	lockWait         time.Duration
+
+	allowBreaking  bool
+	breakingReason string
+
+	applyDryRun      bool
+	applyInteractive bool
+	applyArchive     bool
 )
 
 var applyCmd = &cobra.Command{
@@ -20,106 +39,594 @@ var applyCmd = &cobra.Command{
 	Long: `Applies safe changes from schema.yaml to the database.
 
 By default, only safe (additive) changes are applied. Destructive changes
-(DROP operations) require the --allow-destructive flag.
+(DROP operations) require the --allow-destructive flag, or
+--allow-destructive-on for the objects it names.
+
+Objects listed in policy.never_drop in pgmigrate.yaml, or marked
+protected: true in schema.yaml, are never dropped: apply refuses instead.
+Protection is remembered in the database, so a protected table or column
+removed from schema.yaml stays protected until it is applied with
+protected: false.
+
+Breaking changes (type alterations and the like) are refused unless
+--allow-breaking and --reason are given. The SQL for each breaking change is
+shown, and you must type the database name to confirm. It then runs through
+pgmigrate.dba_migrate() so it lands in history with your reason.
 
-Breaking changes (type alterations, adding NOT NULL) cannot be applied
-automatically. Use pgmigrate.dba_migrate() in psql for those.
+Changes are executed one by one in a single transaction, each through
+pgmigrate.dba_migrate() so it is recorded in history. A live line shows the
+change being applied and its elapsed time. --events writes the same progress
+as newline-delimited JSON for dashboards.
 
 Examples:
   pgmigrate apply                          # Apply safe changes
   pgmigrate apply --allow-destructive      # Include DROP operations
+  pgmigrate apply --allow-destructive-on public.users   # Only DROPs on one table
   pgmigrate apply --auto-approve           # Skip confirmation
-  pgmigrate apply myschema.yaml            # Use specific file`,
+  pgmigrate apply --allow-breaking --reason "Widen email for SSO"
+  pgmigrate apply myschema.yaml            # Use specific file
+  pgmigrate apply --target public.users    # Only apply changes on one table
+  pgmigrate apply --events events.ndjson   # Stream progress events to a file
+  pgmigrate apply --lock-timeout 5s --retries 3   # Don't queue behind long transactions
+  pgmigrate apply --concurrent-indexes     # Don't block writes while building indexes
+  pgmigrate apply --dry-run                # Try every statement, then roll back
+  pgmigrate apply --interactive            # Approve each change individually
+  pgmigrate apply --targets targets.yaml   # Apply to every database of a fleet
+  pgmigrate apply --tenants 'tenant_*'     # Stamp the template onto tenant schemas
+
+With --target or --exclude, only the selected changes are applied. If a
+selected change depends on one that was left out, apply refuses.
+
+--lock-timeout keeps apply from holding an ACCESS EXCLUSIVE lock request
+in the queue behind a long transaction, which would block every other query
+on the table. If the lock is not granted in time (lock_not_available) or a
+deadlock is detected, the transaction is rolled back and retried up to
+--retries times, waiting --retry-backoff and then twice as long each time.
+
+Setting NOT NULL and adding a column with a foreign key do not scan the
+table under an exclusive lock. They run after the transaction as a sequence
+of statements, each committed on its own: the constraint is added NOT VALID,
+then validated, which only needs a weak lock. For NOT NULL, the validated
+check lets SET NOT NULL skip the scan, and the check is dropped afterwards.
+If a step fails, its leftover constraint is dropped. Use
+--no-online-constraints to run them as a single statement instead.
+
+Indexes marked concurrently: true in schema.yaml, or all indexes with
+--concurrent-indexes, are built with CREATE INDEX CONCURRENTLY (and dropped
+with DROP INDEX CONCURRENTLY) in the same phase, so writes are not blocked.
+A build that fails leaves an INVALID index, which is dropped. CONCURRENTLY
+cannot run inside a function, so these statements are not recorded in
+history.
+
+Pending scripts from the data_migrations directory run in the same
+transaction, right after the change named by their "-- after:" line, or
+after all changes. Each runs once and is recorded in history.
+
+--dry-run executes everything in one transaction, with a savepoint per
+statement, reports whether each statement succeeded and how long it took,
+then rolls back. Statements that cannot run in a transaction (CONCURRENTLY)
+are reported as skipped. The statements take their usual locks until the
+rollback, so use --lock-timeout on busy databases.
+
+--interactive walks through the changes one by one, showing the safety level
+and SQL of each: answer y to apply it, n to leave it out, s to leave it and
+all remaining changes out, or q to quit without applying anything. Only the
+approved changes are applied; approving a change that depends on a declined
+one is refused.
+
+--archive (or apply.archive: true in pgmigrate.yaml) keeps the data of
+dropped objects: dropped tables are moved to the pgmigrate_archive schema
+with a timestamp suffix, and dropped columns are first copied there together
+with the table's primary key. See 'pgmigrate archive'.
+
+Hooks from pgmigrate.yaml run before planning (before_plan), before the
+changes are executed (before_apply), after they committed (after_apply) and
+when the apply fails (on_failure). A failing before_* hook aborts the apply.
+
+--targets applies to every database listed in a targets file. All of them
+are planned first, --parallel at a time, holding each one's apply lock, and
+a table of change counts per database is shown. After a single
+confirmation, each database with changes is applied in its own transaction.
+By default the first failure stops the run: databases not yet started are
+skipped, and a failed plan means nothing is applied. --continue-on-error
+applies to all databases that planned cleanly. The final report lists which
+databases changed, were unchanged, skipped or failed.
+
+--tenants stamps the schema named by tenant_template in schema.yaml onto
+every schema matching a pattern, or returned by --tenants-query. Only the
+template's tables are used, renamed into each tenant schema. Each tenant is
+planned on its own and a matrix shows how many tenants need each change.
+After one confirmation each tenant is applied in its own transaction; the
+stop/continue rules of --targets apply.
+
+Apply holds an advisory lock on the database from plan to commit, so
+concurrent deploys cannot race. By default a second apply fails at once;
+--lock-wait makes it wait. See 'pgmigrate lock status'.`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: runApply,
 }
 
 func init() {
-	applyCmd.Flags().BoolVar(&allowDestructive, "allow-destructive", false,
+	addApplyFlags(applyCmd)
+	addFleetFlags(applyCmd)
+	addTenantFlags(applyCmd)
+}
+
+// addApplyFlags registers the flags shared by apply and rollback
+func addApplyFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&allowDestructive, "allow-destructive", false,
 		"Allow destructive changes (DROP TABLE, DROP COLUMN)")
-	applyCmd.Flags().BoolVar(&autoApprove, "auto-approve", false,
+	cmd.Flags().StringArrayVar(&allowDestructiveOn, "allow-destructive-on", nil,
+		"Allow destructive changes only on schema or schema.table (repeatable)")
+	cmd.Flags().BoolVar(&autoApprove, "auto-approve", false,
 		"Skip confirmation prompt")
+	cmd.Flags().BoolVar(&applyFlat, "flat", false,
+		"Show a flat list instead of grouping large plans by table")
+	cmd.Flags().StringVar(&applyEvents, "events", "",
+		"Write progress events as NDJSON to a file (- for stdout)")
+	cmd.Flags().DurationVar(&lockTimeout, "lock-timeout", 0,
+		"Give up waiting for a lock after this long, e.g. 5s (0 waits forever)")
+	cmd.Flags().DurationVar(&statementTimeout, "statement-timeout", 0,
+		"Abort any statement running longer than this (0 for no limit)")
+	cmd.Flags().IntVar(&applyRetries, "retries", 0,
+		"Retry the apply this many times after a lock timeout or deadlock")
+	cmd.Flags().DurationVar(&retryBackoff, "retry-backoff", 5*time.Second,
+		"Wait before the first retry, doubled for each further retry")
+	cmd.Flags().BoolVar(&allowBreaking, "allow-breaking", false,
+		"Apply breaking changes through pgmigrate.dba_migrate() (requires --reason)")
+	cmd.Flags().StringVar(&breakingReason, "reason", "",
+		"Reason recorded in history for breaking changes")
+	cmd.Flags().BoolVar(&applyDryRun, "dry-run", false,
+		"Run the apply in a transaction, report each statement, then roll back")
+	cmd.Flags().BoolVar(&applyArchive, "archive", false,
+		"Move dropped tables and copy dropped columns into pgmigrate_archive")
+	cmd.Flags().BoolVarP(&applyInteractive, "interactive", "i", false,
+		"Approve or decline each change individually")
+	cmd.Flags().DurationVar(&lockWait, "lock-wait", 0,
+		"Wait this long for another apply to finish (0 fails immediately)")
+	addFilterFlags(cmd)
+	addOnlineFlags(cmd)
 }
 
 func runApply(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
 	// Determine schema file
-	schemaFile := "schema.yaml"
+	schemaFile := defaultSchemaFile()
 	if len(args) > 0 {
 		schemaFile = args[0]
 	}
 
+	if err := validateApplyFlags(); err != nil {
+		return err
+	}
+
 	// Read YAML content
 	yamlContent, err := os.ReadFile(schemaFile)
 	if err != nil {
-		return fmt.Errorf("cannot read %s: %w", schemaFile, err)
+		return withCode(codeFile, fmt.Errorf("cannot read %s: %w", schemaFile, err))
+	}
+
+	if tenantMode() {
+		return runTenantApply(cmd, schemaFile, yamlContent)
+	}
+	if targetsFile != "" {
+		return runFleetApply(cmd, schemaFile, yamlContent)
 	}
 
 	// Connect to database
-	conn, err := db.Connect(getDatabaseURL())
+	conn, err := connect(ctx)
 	if err != nil {
 		return err
 	}
-	defer conn.Close(cmd.Context())
+	defer db.Close(conn)
 
-	// Check extension
-	if err := db.CheckExtension(conn); err != nil {
+	// Serialize applies against this database for the whole plan+apply
+	if err := db.AcquireApplyLock(ctx, conn, lockWait); err != nil {
 		return err
 	}
+	defer db.ReleaseApplyLock(ctx, conn)
+
+	return applySchema(cmd, conn, applySource{
+		SchemaFile: schemaFile,
+		YAML:       yamlContent,
+		Reason:     "pgmigrate apply",
+	})
+}
+
+// validateApplyFlags checks the apply flags before connecting
+func validateApplyFlags() error {
+	if allowBreaking && strings.TrimSpace(breakingReason) == "" {
+		return withCode(codeUsage, fmt.Errorf("--allow-breaking requires --reason"))
+	}
+	if err := (db.ChangeFilter{Targets: allowDestructiveOn}).Validate(); err != nil {
+		return withCode(codeUsage, fmt.Errorf("--allow-destructive-on: %w", err))
+	}
+	if applyInteractive && (autoApprove || jsonOutput()) {
+		return withCode(codeUsage, fmt.Errorf("--interactive cannot be combined with --auto-approve or --output json"))
+	}
+	return checkPolicyFlags()
+}
+
+// applySource is the schema an apply plans towards
+type applySource struct {
+	SchemaFile string
+	YAML       []byte
+
+	// Reason is recorded in history for each change
+	Reason string
 
-	// Get plan first to show what will happen
-	plan, err := db.Plan(conn, string(yamlContent))
+	// Rollback plans a stored schema, so no data migrations are read
+	Rollback bool
+}
+
+// applySchema plans src against the database and applies it through the
+// usual safety gates. The caller holds the apply lock.
+func applySchema(cmd *cobra.Command, conn *pgx.Conn, src applySource) error {
+	ctx := cmd.Context()
+
+	command := cmd.Name()
+	schemaFile, yamlContent := src.SchemaFile, src.YAML
+
+	if err := runHooks(ctx, conn, hookPayload{Hook: hookBeforePlan, Command: command, SchemaFile: schemaFile}); err != nil {
+		return err
+	}
+
+	// Get plan first to show what will happen. Rollbacks read no data
+	// migrations.
+	dataMigrationsFile := schemaFile
+	if src.Rollback {
+		dataMigrationsFile = ""
+	}
+	plan, schemaOpts, err := planSchema(ctx, conn, dataMigrationsFile, yamlContent)
+	if err != nil {
+		return err
+	}
+
+	// Restrict to --target/--exclude
+	filtered := !changeFilter().IsEmpty()
+	plan, err = filterPlan(plan)
 	if err != nil {
 		return err
 	}
 
-	// Handle empty plan
+	// Handle empty plan. Protection added to an otherwise unchanged schema
+	// is still remembered.
 	if plan.IsEmpty() {
-		output.PrintPlanTerraform(plan)
-		return nil
+		if err := rememberProtected(ctx, conn, schemaOpts); err != nil {
+			return err
+		}
+		return printApplyOutcome(applyReport{Status: "no_changes", Plan: plan})
 	}
 
 	// Check for breaking changes
-	if plan.HasBreaking() {
-		output.PrintPlanTerraform(plan)
-		fmt.Println()
-		output.PrintError("Breaking changes detected. These cannot be applied automatically.")
-		fmt.Println("Use pgmigrate.dba_migrate() in psql to apply breaking changes manually.")
-		return fmt.Errorf("breaking changes require manual intervention")
+	applyBreaking := plan.HasBreaking() && allowBreaking
+	if plan.HasBreaking() && !allowBreaking {
+		if !jsonOutput() {
+			output.PrintPlan(plan, applyFlat)
+			fmt.Println()
+			output.PrintError("Breaking changes detected. These cannot be applied automatically.")
+			fmt.Println("Use --allow-breaking --reason \"...\" to apply them through pgmigrate.dba_migrate().")
+		}
+		return withCode(codeBreakingChanges, fmt.Errorf("breaking changes require --allow-breaking"))
 	}
 
-	// Check for destructive without flag
-	if plan.HasDestructive() && !allowDestructive {
-		output.PrintPlanTerraform(plan)
-		fmt.Println()
-		output.PrintWarning("Destructive changes will be skipped.")
-		fmt.Println("Use --allow-destructive to include them.")
-		fmt.Println()
-	} else {
-		output.PrintPlanTerraform(plan)
+	if !jsonOutput() {
+		output.PrintPlan(plan, applyFlat)
 		fmt.Println()
+
+		// Warn about destructive without flag
+		if n := plan.DestructiveCount() - countAllowedDestructive(plan); n > 0 {
+			output.PrintWarning(fmt.Sprintf("%d destructive change(s) will be skipped.", n))
+			fmt.Println("Use --allow-destructive or --allow-destructive-on to include them.")
+			fmt.Println()
+		}
+	}
+
+	// Refuse to drop protected objects, whatever the flags
+	if err := checkProtected(ctx, conn, plan, schemaOpts); err != nil {
+		return err
+	}
+
+	archive, err := archiveEnabled(cmd)
+	if err != nil {
+		return err
+	}
+	if archive && countAllowedDestructive(plan) > 0 && !jsonOutput() {
+		fmt.Printf("Dropped tables and columns will be archived in %s.\n\n", db.ArchiveSchema)
 	}
 
 	// Skip if only destructive changes and not allowed
 	safeCount := plan.SafeCount()
-	if safeCount == 0 && !allowDestructive {
-		fmt.Println("No safe changes to apply.")
-		return nil
+	if safeCount == 0 && len(plan.DataMigrations) == 0 && countAllowedDestructive(plan) == 0 && !applyBreaking {
+		if err := rememberProtected(ctx, conn, schemaOpts); err != nil {
+			return err
+		}
+		notifyOutcome(ctx, conn, hookPayload{Command: command, SchemaFile: schemaFile}, plan.Destructive)
+		return printApplyOutcome(applyReport{Status: "no_safe_changes", Plan: plan})
+	}
+
+	// Show the SQL that breaking changes will run
+	if applyBreaking && !jsonOutput() {
+		fmt.Println(output.Bold("Breaking changes will run through pgmigrate.dba_migrate():"))
+		fmt.Println()
+		if err := output.PrintChangeSQL(plan.Breaking); err != nil {
+			return err
+		}
+		fmt.Printf("Reason: %s\n\n", breakingReason)
 	}
 
-	// Confirm unless auto-approve
-	if !autoApprove {
-		if !output.ConfirmPrompt("Do you want to apply these changes?") {
-			fmt.Println("Apply cancelled.")
-			return nil
+	// Let the user pick the changes to apply
+	var declined []db.Change
+	if applyInteractive {
+		selected, rejected, err := chooseChanges(plan, applyBreaking)
+		if err != nil {
+			return err
+		}
+		if selected == nil || len(changesToApply(selected, applyBreaking))+len(selected.DataMigrations) == 0 {
+			return printApplyOutcome(applyReport{Status: "cancelled", Plan: plan})
+		}
+		plan, declined = selected, rejected
+		applyBreaking = applyBreaking && plan.HasBreaking()
+	}
+
+	if applyDryRun {
+		return runDryRun(ctx, conn, plan, applyBreaking, archive, src.Reason)
+	}
+
+	// Confirm unless auto-approve. Interactive approval only needs the
+	// database name typed for breaking changes.
+	if applyInteractive {
+		if applyBreaking && !confirmApply(ctx, conn, true) {
+			return printApplyOutcome(applyReport{Status: "cancelled", Plan: plan})
+		}
+	} else if !autoApprove {
+		if jsonOutput() {
+			return withCode(codeConfirmationRequired,
+				fmt.Errorf("apply with --output json needs --auto-approve"))
+		}
+		if !confirmApply(ctx, conn, applyBreaking) {
+			return printApplyOutcome(applyReport{Status: "cancelled", Plan: plan})
 		}
 	}
 
 	// Apply changes
-	result, err := db.Apply(conn, string(yamlContent), allowDestructive)
+	opts, closeEvents, err := applyOptions(src.Reason, filtered)
 	if err != nil {
 		return err
 	}
+	defer closeEvents()
+	if applyBreaking {
+		opts.BreakingReason = breakingReason
+	}
+	opts.Archive = archive
 
-	output.PrintApplyResult(result)
+	hook := hookPayload{Hook: hookBeforeApply, Command: command, SchemaFile: schemaFile,
+		Changes: changesToApply(plan, applyBreaking)}
+	if err := runHooks(ctx, conn, hook); err != nil {
+		return err
+	}
+
+	lastHistoryID, err := db.LatestHistoryID(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Apply(ctx, conn, plan, destructiveEnabled(), opts)
+	if err != nil {
+		hook.Hook, hook.Result, hook.Error = hookOnFailure, result, err.Error()
+		if hookErr := runHooks(ctx, conn, hook); hookErr != nil && !jsonOutput() {
+			output.PrintWarning(hookErr.Error())
+		}
+		notifyOutcome(ctx, conn, hook, nil)
+		return applyFailed(plan, result, err)
+	}
+	notifyOutcome(ctx, conn, hookPayload{Command: command, SchemaFile: schemaFile, Result: result}, nil)
+
+	// Remember protected objects so they stay protected once removed from
+	// schema.yaml
+	if err := rememberProtected(ctx, conn, schemaOpts); err != nil {
+		return fmt.Errorf("changes were applied, but %w", err)
+	}
+
+	// Keep the applied YAML so 'pgmigrate rollback' can return to it. A
+	// partial apply leaves the database short of the YAML, so it is not kept.
+	if !filtered && len(declined) == 0 {
+		if err := db.RecordSchemaSnapshot(ctx, conn, lastHistoryID, schemaFile, yamlContent); err != nil {
+			return fmt.Errorf("changes were applied, but %w", err)
+		}
+	}
+
+	hook.Hook, hook.Result = hookAfterApply, result
+	if err := runHooks(ctx, conn, hook); err != nil {
+		return fmt.Errorf("changes were applied, but %w", err)
+	}
+
+	return printApplyOutcome(applyReport{Status: "applied", Plan: plan, Result: result, Declined: declined})
+}
+
+// applyFailed reports which changes a failed or interrupted apply
+// committed before it stopped, and returns err with them as JSON data
+func applyFailed(plan *db.PlanResult, result *db.ApplyResult, err error) error {
+	if result == nil {
+		return err
+	}
+	if !jsonOutput() {
+		fmt.Println()
+		output.PrintCommitted(result)
+	}
+	return withData(errorCode(err), err, applyReport{Status: "failed", Plan: plan, Result: result})
+}
+
+// changesToApply returns the changes db.Apply will execute for the plan
+func changesToApply(plan *db.PlanResult, applyBreaking bool) []db.Change {
+	changes := append([]db.Change{}, plan.Safe...)
+	for _, c := range plan.Destructive {
+		if destructiveAllowed(c) {
+			changes = append(changes, c)
+		}
+	}
+	if applyBreaking {
+		changes = append(changes, plan.Breaking...)
+	}
+	return changes
+}
+
+// archiveEnabled returns --archive if given, else the apply.archive default
+// from the project config
+func archiveEnabled(cmd *cobra.Command) (bool, error) {
+	if cmd.Flags().Changed("archive") {
+		return applyArchive, nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return false, err
+	}
+	return cfg.Apply.Archive, nil
+}
+
+// countAllowedDestructive returns how many destructive changes the flags
+// permit
+func countAllowedDestructive(plan *db.PlanResult) int {
+	n := 0
+	for _, c := range plan.Destructive {
+		if destructiveAllowed(c) {
+			n++
+		}
+	}
+	return n
+}
+
+// runDryRun executes the plan in a rolled back transaction and reports each
+// statement. It fails if any statement failed.
+func runDryRun(ctx context.Context, conn *pgx.Conn, plan *db.PlanResult, applyBreaking, archive bool, reason string) error {
+	opts := db.ApplyOptions{
+		Reason:            reason + " --dry-run",
+		DestructiveFilter: destructiveAllowed,
+		Archive:           archive,
+		LockTimeout:       lockTimeout,
+		StatementTimeout:  statementTimeout,
+	}
+	if applyBreaking {
+		opts.BreakingReason = breakingReason
+	}
+
+	result, err := db.DryRun(ctx, conn, plan, destructiveEnabled(), opts)
+	if err != nil {
+		return err
+	}
+
+	report := applyReport{Status: "dry_run", Plan: plan, DryRun: result}
+	if !jsonOutput() {
+		output.PrintDryRunResult(result)
+	}
+	if result.Failed > 0 {
+		return withData(codeDryRunFailed,
+			fmt.Errorf("dry run: %d of %d statement(s) failed", result.Failed, len(result.Statements)), report)
+	}
+	if jsonOutput() {
+		return output.PrintEnvelope(currentCommand, report)
+	}
 	return nil
 }
+
+// confirmApply asks before applying. Breaking changes need the database
+// name typed out rather than a y/N answer.
+func confirmApply(ctx context.Context, conn *pgx.Conn, breaking bool) bool {
+	if !breaking {
+		return output.ConfirmPrompt("Do you want to apply these changes?")
+	}
+
+	dbName, err := db.CurrentDatabase(ctx, conn)
+	if err != nil {
+		output.PrintError(err.Error())
+		return false
+	}
+	return output.TypedConfirmPrompt("This applies breaking changes that may fail or corrupt data.", dbName)
+}
+
+// applyReport is the JSON data of the apply and rollback commands
+type applyReport struct {
+	Status string           `json:"status"`
+	Plan   *db.PlanResult   `json:"plan"`
+	Result *db.ApplyResult  `json:"result,omitempty"`
+	DryRun *db.DryRunResult `json:"dry_run,omitempty"`
+
+	// Declined lists the changes turned down with --interactive
+	Declined []db.Change `json:"declined,omitempty"`
+}
+
+// printApplyOutcome reports how apply ended. Status is one of no_changes,
+// no_safe_changes, cancelled or applied; dry runs report dry_run. Failed
+// applies report failed through applyFailed.
+func printApplyOutcome(report applyReport) error {
+	if jsonOutput() {
+		return output.PrintEnvelope(currentCommand, report)
+	}
+
+	switch report.Status {
+	case "no_changes":
+		output.PrintPlan(report.Plan, applyFlat)
+	case "no_safe_changes":
+		fmt.Println("No safe changes to apply.")
+	case "cancelled":
+		fmt.Println("Apply cancelled.")
+	case "applied":
+		output.PrintApplyResult(report.Result)
+		if len(report.Declined) > 0 {
+			fmt.Println()
+			output.PrintWarning(fmt.Sprintf(
+				"The database now intentionally differs from schema.yaml. %d declined change(s) will show up in the next plan:",
+				len(report.Declined)))
+			for _, c := range report.Declined {
+				fmt.Printf("  %s\n", c.Key())
+			}
+		}
+	}
+	return nil
+}
+
+// applyOptions builds the executor options: the history reason and the
+// progress and --events consumers. The returned func closes the events file.
+func applyOptions(reason string, filtered bool) (db.ApplyOptions, func(), error) {
+	opts := db.ApplyOptions{
+		Reason:            reason,
+		DestructiveFilter: destructiveAllowed,
+		LockTimeout:       lockTimeout,
+		StatementTimeout:  statementTimeout,
+		Retries:           applyRetries,
+		RetryBackoff:      retryBackoff,
+	}
+	if filtered {
+		opts.Reason += " " + filterDescription()
+	}
+
+	var handlers []func(db.Event)
+	closeEvents := func() {}
+
+	switch applyEvents {
+	case "":
+	case "-":
+		handlers = append(handlers, output.NewEventWriter(os.Stdout).Handle)
+	default:
+		f, err := os.Create(applyEvents)
+		if err != nil {
+			return opts, nil, withCode(codeFile, fmt.Errorf("cannot create %s: %w", applyEvents, err))
+		}
+		closeEvents = func() { f.Close() }
+		handlers = append(handlers, output.NewEventWriter(f).Handle)
+	}
+
+	// The live progress line shares stdout, so it is left out when stdout
+	// carries JSON
+	if !jsonOutput() && applyEvents != "-" {
+		handlers = append(handlers, output.NewProgress().Handle)
+	}
+
+	opts.OnEvent = func(ev db.Event) {
+		for _, h := range handlers {
+			h(ev)
+		}
+	}
+	return opts, closeEvents, nil
+}
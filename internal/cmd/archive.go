@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var (
+	archivePurgeAll       bool
+	archivePurgeOlderThan time.Duration
+)
+
+var archiveCmd = &cobra.Command{
+	Use:   "archive",
+	Short: "Manage archived tables and columns",
+	Long: `apply --archive keeps the data of dropped objects in the pgmigrate_archive
+schema. Dropped tables are moved there with a timestamp suffix; dropped
+columns are copied there with the table's primary key before the drop.
+
+Examples:
+  pgmigrate archive list                    # Show archives
+  pgmigrate archive restore 3               # Put archive 3 back
+  pgmigrate archive purge 3                 # Drop archive 3 for good
+  pgmigrate archive purge --older-than 720h # Drop archives older than 30 days`,
+}
+
+var archiveListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List archived tables and columns",
+	Args:  cobra.NoArgs,
+	RunE:  runArchiveList,
+}
+
+var archiveRestoreCmd = &cobra.Command{
+	Use:   "restore <id>",
+	Short: "Restore an archived table or column",
+	Long: `Moves an archived table back to its schema under its original name, or
+adds an archived column back to its table and fills it by primary key. Rows
+added since the drop get NULL. Column defaults and constraints are not
+restored. The object is no longer in schema.yaml, so add it there again or
+the next apply will plan to drop it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runArchiveRestore,
+}
+
+var archivePurgeCmd = &cobra.Command{
+	Use:   "purge [id]",
+	Short: "Drop archived tables and columns for good",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runArchivePurge,
+}
+
+func init() {
+	archivePurgeCmd.Flags().BoolVar(&autoApprove, "auto-approve", false,
+		"Skip confirmation prompt")
+	archivePurgeCmd.Flags().BoolVar(&archivePurgeAll, "all", false,
+		"Purge every archive")
+	archivePurgeCmd.Flags().DurationVar(&archivePurgeOlderThan, "older-than", 0,
+		"Purge archives older than this, e.g. 720h")
+
+	archiveCmd.AddCommand(archiveListCmd)
+	archiveCmd.AddCommand(archiveRestoreCmd)
+	archiveCmd.AddCommand(archivePurgeCmd)
+}
+
+func runArchiveList(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close(conn)
+
+	archives, err := db.ListArchives(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		if archives == nil {
+			archives = []db.Archive{}
+		}
+		return output.PrintEnvelope("archive list", map[string]interface{}{"archives": archives})
+	}
+
+	output.PrintArchiveTable(archives)
+	return nil
+}
+
+func runArchiveRestore(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return withCode(codeUsage, fmt.Errorf("invalid archive id %q", args[0]))
+	}
+
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close(conn)
+
+	if err := db.AcquireApplyLock(ctx, conn, 0); err != nil {
+		return err
+	}
+	defer db.ReleaseApplyLock(ctx, conn)
+
+	a, err := db.GetArchive(ctx, conn, id)
+	if err != nil {
+		return err
+	}
+	if a == nil {
+		return fmt.Errorf("archive %d not found", id)
+	}
+
+	if err := db.RestoreArchive(ctx, conn, a, fmt.Sprintf("pgmigrate archive restore %d", id)); err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return output.PrintEnvelope("archive restore", map[string]interface{}{"restored": a})
+	}
+	output.PrintSuccess(fmt.Sprintf("Restored %s from %s.", a.Object(), output.ArchiveLocation(*a)))
+	fmt.Println("Add it back to schema.yaml, or the next apply will plan to drop it.")
+	return nil
+}
+
+func runArchivePurge(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	if (len(args) > 0) == (archivePurgeAll || archivePurgeOlderThan > 0) {
+		return withCode(codeUsage, fmt.Errorf("give an archive id, --all or --older-than"))
+	}
+
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close(conn)
+
+	if err := db.AcquireApplyLock(ctx, conn, 0); err != nil {
+		return err
+	}
+	defer db.ReleaseApplyLock(ctx, conn)
+
+	archives, err := db.ListArchives(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	var selected []db.Archive
+	switch {
+	case len(args) > 0:
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return withCode(codeUsage, fmt.Errorf("invalid archive id %q", args[0]))
+		}
+		for _, a := range archives {
+			if a.ID == id {
+				selected = append(selected, a)
+			}
+		}
+		if len(selected) == 0 {
+			return fmt.Errorf("archive %d not found", id)
+		}
+	case archivePurgeAll:
+		selected = archives
+	default:
+		cutoff := time.Now().Add(-archivePurgeOlderThan)
+		for _, a := range archives {
+			if a.ArchivedAt.Before(cutoff) {
+				selected = append(selected, a)
+			}
+		}
+	}
+
+	if len(selected) > 0 && !autoApprove && !jsonOutput() {
+		output.PrintArchiveTable(selected)
+		fmt.Println()
+		if !output.ConfirmPrompt(fmt.Sprintf("Permanently drop %d archive(s)?", len(selected))) {
+			fmt.Println("Purge cancelled.")
+			return nil
+		}
+	} else if len(selected) > 0 && !autoApprove {
+		return withCode(codeConfirmationRequired,
+			fmt.Errorf("archive purge with --output json needs --auto-approve"))
+	}
+
+	for i := range selected {
+		a := &selected[i]
+		if err := db.PurgeArchive(ctx, conn, a, "pgmigrate archive purge"); err != nil {
+			return err
+		}
+	}
+
+	if jsonOutput() {
+		if selected == nil {
+			selected = []db.Archive{}
+		}
+		return output.PrintEnvelope("archive purge", map[string]interface{}{"purged": selected})
+	}
+	output.PrintSuccess(fmt.Sprintf("Purged %d archive(s).", len(selected)))
+	return nil
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/schema"
+)
+
+// templateEnvPrefix marks the environment variables data migration
+// templates may read. Others, such as credentials, are not exposed.
+const templateEnvPrefix = "PGMIGRATE_"
+
+// dataMigrationData is passed to .sql.tmpl data migrations
+type dataMigrationData struct {
+	Database string
+	Env      map[string]string
+}
+
+// pendingDataMigrations loads the scripts in the data_migrations directory
+// next to the schema file, renders them and returns those not yet run. A
+// script edited after it ran gets a warning.
+func pendingDataMigrations(ctx context.Context, conn *pgx.Conn, schemaFile string) ([]db.DataMigration, error) {
+	dir := filepath.Join(filepath.Dir(schemaFile), schema.DataMigrationsDir)
+	scripts, err := schema.LoadDataMigrations(dir)
+	if err != nil {
+		return nil, withCode(codeFile, err)
+	}
+	if len(scripts) == 0 {
+		return nil, nil
+	}
+
+	dbName, err := db.CurrentDatabase(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	data := dataMigrationData{Database: dbName, Env: templateEnv(os.Environ())}
+
+	migrations := make([]db.DataMigration, len(scripts))
+	for i, s := range scripts {
+		sql, err := s.Render(data)
+		if err != nil {
+			return nil, withCode(codeFile, err)
+		}
+		sum := sha256.Sum256([]byte(s.Body))
+		migrations[i] = db.DataMigration{
+			Name:     s.Name,
+			After:    s.After,
+			Checksum: hex.EncodeToString(sum[:]),
+			SQL:      sql,
+		}
+	}
+
+	pending, changed, err := db.PendingDataMigrations(ctx, conn, migrations)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range changed {
+		fmt.Fprintf(os.Stderr, "Warning: data migration %s was edited after it ran; it is not run again\n", name)
+	}
+	return pending, nil
+}
+
+// templateEnv returns the variables of environ starting with
+// templateEnvPrefix, keyed by their full name
+func templateEnv(environ []string) map[string]string {
+	env := map[string]string{}
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(k, templateEnvPrefix) {
+			env[k] = v
+		}
+	}
+	return env
+}
@@ -0,0 +1,13 @@
+package cmd
+
+import "testing"
+
+func TestTemplateEnvOnlyExposesPrefixed(t *testing.T) {
+	env := templateEnv([]string{"PGPASSWORD=secret", "PGMIGRATE_TENANT=acme", "HOME=/root", "PGMIGRATE_EMPTY="})
+	if len(env) != 2 || env["PGMIGRATE_TENANT"] != "acme" {
+		t.Errorf("got %v, want only the PGMIGRATE_ variables", env)
+	}
+	if _, ok := env["PGPASSWORD"]; ok {
+		t.Error("PGPASSWORD exposed to templates")
+	}
+}
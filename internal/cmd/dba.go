@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dbaReason string
+)
+
+var dbaCmd = &cobra.Command{
+	Use:   "dba <sql>",
+	Short: "Run SQL through pgmigrate.dba_migrate()",
+	Long: `Executes SQL through pgmigrate.dba_migrate(), which records it in the
+migration history together with a reason. Use this for changes that the
+declarative workflow cannot express.
+
+The apply lock is held while the SQL runs, so it cannot race a deploy.
+
+Examples:
+  pgmigrate dba "ALTER TABLE users ALTER COLUMN email TYPE varchar(500)" \
+    --reason "Expand email column size"`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDBA,
+}
+
+func init() {
+	dbaCmd.Flags().StringVar(&dbaReason, "reason", "",
+		"Reason recorded in history (required)")
+	dbaCmd.Flags().BoolVar(&autoApprove, "auto-approve", false,
+		"Skip confirmation prompt")
+}
+
+func runDBA(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	sql := args[0]
+	if strings.TrimSpace(dbaReason) == "" {
+		return withCode(codeUsage, fmt.Errorf("--reason is required"))
+	}
+
+	// Connect to database
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close(conn)
+
+	if err := db.AcquireApplyLock(ctx, conn, 0); err != nil {
+		return err
+	}
+	defer db.ReleaseApplyLock(ctx, conn)
+
+	// Confirm unless auto-approve
+	if !autoApprove {
+		if jsonOutput() {
+			return withCode(codeConfirmationRequired,
+				fmt.Errorf("dba with --output json needs --auto-approve"))
+		}
+		fmt.Println(output.Yellow(sql))
+		fmt.Printf("Reason: %s\n\n", dbaReason)
+		if !output.ConfirmPrompt("Do you want to run this SQL?") {
+			fmt.Println("Cancelled.")
+			return nil
+		}
+	}
+
+	if err := db.DBAMigrate(ctx, conn, sql, dbaReason); err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return output.PrintEnvelope("dba", map[string]string{"sql": sql, "reason": dbaReason})
+	}
+
+	output.PrintSuccess("SQL executed and recorded in history.")
+	return nil
+}
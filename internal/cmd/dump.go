@@ -10,7 +10,11 @@ import (
 )
 
 var (
-	dumpOutput string
+	dumpFile string
+
+	// dumpOutputFile is set when -o/--output was given a file name, as
+	// before --output became the global output format
+	dumpOutputFile bool
 )
 
 var dumpCmd = &cobra.Command{
@@ -26,45 +30,84 @@ This is useful for:
 Examples:
   pgmigrate dump public                    # Dump public schema
   pgmigrate dump public api                # Dump multiple schemas
-  pgmigrate dump public -o schema.yaml     # Write to file`,
+  pgmigrate dump public -f schema.yaml     # Write to file
+  pgmigrate dump public -o json            # YAML inside a JSON envelope`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: runDump,
 }
 
 func init() {
-	dumpCmd.Flags().StringVarP(&dumpOutput, "output", "o", "-",
+	dumpCmd.Flags().StringVarP(&dumpFile, "file", "f", "-",
 		"Output file (- for stdout)")
+	dumpCmd.Flags().VarP(dumpOutputValue{}, "output", "o",
+		"Output format: text, json (a file name is deprecated, use --file)")
+}
+
+// dumpOutputValue shadows the global --output on dump. Format names set
+// the output format; anything else is taken as the file to write, which is
+// what -o meant for dump before it became the global output format.
+type dumpOutputValue struct{}
+
+func (dumpOutputValue) String() string { return outputFormat }
+func (dumpOutputValue) Type() string   { return "string" }
+
+func (dumpOutputValue) Set(v string) error {
+	if v == "text" || v == "json" {
+		outputFormat = v
+		return nil
+	}
+	dumpFile, dumpOutputFile = v, true
+	return nil
 }
 
 func runDump(cmd *cobra.Command, args []string) error {
-	// Connect to database
-	conn, err := db.Connect(getDatabaseURL())
-	if err != nil {
-		return err
+	ctx := cmd.Context()
+
+	if dumpOutputFile {
+		if cmd.Flags().Changed("file") {
+			return withCode(codeUsage, fmt.Errorf("--output with a file name cannot be combined with --file"))
+		}
+		fmt.Fprintln(os.Stderr, "Flag --output with a file name has been deprecated, use --file instead")
 	}
-	defer conn.Close(cmd.Context())
 
-	// Check extension
-	if err := db.CheckExtension(conn); err != nil {
+	// Connect to database
+	conn, err := connect(ctx)
+	if err != nil {
 		return err
 	}
+	defer db.Close(conn)
 
 	// Dump schemas
-	yaml, err := db.Dump(conn, args)
+	yaml, err := db.Dump(ctx, conn, args)
 	if err != nil {
 		return err
 	}
 
 	// Output
-	if dumpOutput == "-" {
+	if dumpFile == "-" {
+		if jsonOutput() {
+			return output.PrintEnvelope("dump", dumpReport{Schemas: args, YAML: yaml})
+		}
 		fmt.Print(yaml)
 		return nil
 	}
 
-	if err := os.WriteFile(dumpOutput, []byte(yaml), 0644); err != nil {
-		return fmt.Errorf("failed to write %s: %w", dumpOutput, err)
+	if err := os.WriteFile(dumpFile, []byte(yaml), 0644); err != nil {
+		return withCode(codeFile, fmt.Errorf("failed to write %s: %w", dumpFile, err))
 	}
 
-	output.PrintSuccess(fmt.Sprintf("Schema written to %s", dumpOutput))
+	if jsonOutput() {
+		return output.PrintEnvelope("dump", dumpReport{Schemas: args, File: dumpFile})
+	}
+
+	output.PrintSuccess(fmt.Sprintf("Schema written to %s", dumpFile))
 	return nil
 }
+
+// dumpReport is the JSON data of the dump command. YAML is only set when
+// the schema was not written to a file.
+type dumpReport struct {
+	Schemas []string `json:"schemas"`
+	File    string   `json:"file,omitempty"`
+	YAML    string   `json:"yaml,omitempty"`
+}
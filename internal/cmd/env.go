@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matroidbe/pgmigrate/internal/config"
+	"github.com/matroidbe/pgmigrate/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Show the environments of the project config",
+	Long: `Environments in pgmigrate.yaml name the databases a project deploys to,
+such as dev, staging and prod. Select one with --env on any command: its
+URL is used instead of DATABASE_URL, and its policy and hooks add to the
+project's own.
+
+Example:
+  pgmigrate env list
+  pgmigrate apply --env staging`,
+	Annotations: map[string]string{"config": "optional"},
+}
+
+var envListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the configured environments",
+	Args:  cobra.NoArgs,
+	RunE:  runEnvList,
+}
+
+func init() {
+	envCmd.AddCommand(envListCmd)
+}
+
+// envEntry is one environment as listed by env list
+type envEntry struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Source      string   `json:"source"`
+	Policies    []string `json:"policies"`
+	Selected    bool     `json:"selected"`
+}
+
+func runEnvList(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	entries := []envEntry{}
+	for _, name := range cfg.EnvironmentNames() {
+		env := cfg.Environments[name]
+		entries = append(entries, envEntry{
+			Name:        name,
+			Description: env.Description,
+			Source:      env.Source(),
+			Policies:    policyNames(env.Policy),
+			Selected:    name == envName,
+		})
+	}
+
+	if jsonOutput() {
+		return output.PrintEnvelope("env list", map[string]interface{}{
+			"config":       cfg.File,
+			"environments": entries,
+		})
+	}
+
+	if cfg.File == "" {
+		fmt.Printf("No %s found in this or a parent directory.\n", config.FileName)
+		return nil
+	}
+	if len(entries) == 0 {
+		fmt.Printf("No environments in %s.\n", cfg.File)
+		return nil
+	}
+
+	fmt.Println(output.Bold("Environments") + " " + output.Faint("("+cfg.File+")"))
+	fmt.Println()
+	fmt.Printf("  %-12s %-40s %s\n", "NAME", "DATABASE", "POLICY")
+	for _, e := range entries {
+		marker := " "
+		if e.Selected {
+			marker = "*"
+		}
+		policies := strings.Join(e.Policies, ", ")
+		if policies == "" {
+			policies = "-"
+		}
+		fmt.Printf("%s %-12s %-40s %s\n", marker, e.Name, e.Source, policies)
+		if e.Description != "" {
+			fmt.Printf("  %-12s %s\n", "", output.Faint(e.Description))
+		}
+	}
+	return nil
+}
+
+// policyNames lists the settings of a policy, as written in the config
+func policyNames(p config.Policy) []string {
+	names := []string{}
+	if p.RequireInteractive {
+		names = append(names, "require_interactive")
+	}
+	if p.ForbidDestructive {
+		names = append(names, "forbid_destructive")
+	}
+	if p.ForbidBreaking {
+		names = append(names, "forbid_breaking")
+	}
+	for _, pattern := range p.NeverDrop {
+		names = append(names, "never_drop "+pattern)
+	}
+	return names
+}
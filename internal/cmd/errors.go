@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/output"
+)
+
+// Error codes reported in the JSON envelope
+const (
+	codeError                = "error"
+	codeUsage                = "usage_error"
+	codeFile                 = "file_error"
+	codeConnection           = "connection_error"
+	codeExtensionMissing     = "extension_missing"
+	codeDatabase             = "database_error"
+	codeDependency           = "dependency_error"
+	codeBreakingChanges      = "breaking_changes"
+	codeConfirmationRequired = "confirmation_required"
+	codeLockHeld             = "lock_held"
+	codeHookFailed           = "hook_failed"
+	codeDryRunFailed         = "dry_run_failed"
+	codePolicyViolation      = "policy_violation"
+	codeTargetsFailed        = "targets_failed"
+	codeInterrupted          = "interrupted"
+	codeTimeout              = "timeout"
+)
+
+// codedError attaches a machine-readable code to an error
+type codedError struct {
+	code string
+	err  error
+	data interface{}
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// withCode wraps err with a machine-readable code
+func withCode(code string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// withData wraps err with a code and the command's result so far, which
+// is reported as the data of the JSON error envelope
+func withData(code string, err error, data interface{}) error {
+	return &codedError{code: code, err: err, data: data}
+}
+
+// errorCode returns the machine-readable code for an error
+func errorCode(err error) string {
+	// A cancelled context surfaces through whatever was running, so it
+	// wins over the code of that operation
+	if errors.Is(err, context.Canceled) {
+		return codeInterrupted
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return codeTimeout
+	}
+
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+
+	if errors.Is(err, db.ErrExtensionNotInstalled) {
+		return codeExtensionMissing
+	}
+
+	var lockErr *db.LockHeldError
+	if errors.As(err, &lockErr) {
+		return codeLockHeld
+	}
+
+	var depErr *db.DependencyError
+	if errors.As(err, &depErr) {
+		return codeDependency
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return codeDatabase
+	}
+
+	return codeError
+}
+
+// sqlState returns the Postgres SQLSTATE of an error, if it has one
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// ReportError prints a command error in the selected output format
+func ReportError(err error) {
+	if jsonOutput() {
+		var data interface{}
+		var coded *codedError
+		if errors.As(err, &coded) {
+			data = coded.data
+		}
+		output.PrintErrorEnvelope(currentCommand, output.EnvelopeError{
+			Code:     errorCode(err),
+			Message:  err.Error(),
+			SQLState: sqlState(err),
+		}, data)
+		return
+	}
+
+	output.PrintError(err.Error())
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/spf13/cobra"
+)
+
+var (
+	targetFilters  []string
+	excludeFilters []string
+)
+
+// addFilterFlags registers --target and --exclude on a command
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&targetFilters, "target", nil,
+		"Only include changes on schema or schema.table (repeatable)")
+	cmd.Flags().StringArrayVar(&excludeFilters, "exclude", nil,
+		"Leave out changes on schema or schema.table (repeatable)")
+}
+
+// changeFilter returns the filter built from --target and --exclude
+func changeFilter() db.ChangeFilter {
+	return db.ChangeFilter{Targets: targetFilters, Excludes: excludeFilters}
+}
+
+// filterPlan restricts the plan to the changes selected on the command line
+func filterPlan(plan *db.PlanResult) (*db.PlanResult, error) {
+	filter := changeFilter()
+	if err := filter.Validate(); err != nil {
+		return nil, withCode(codeUsage, err)
+	}
+
+	filtered, err := plan.Filter(filter)
+	if err != nil {
+		var depErr *db.DependencyError
+		if errors.As(err, &depErr) {
+			return nil, fmt.Errorf("%w. Add it with --target or remove it from --exclude", err)
+		}
+		return nil, err
+	}
+
+	return filtered, nil
+}
+
+// filterDescription describes the active filter for history records
+func filterDescription() string {
+	parts := []string{}
+	for _, t := range targetFilters {
+		parts = append(parts, "--target "+t)
+	}
+	for _, e := range excludeFilters {
+		parts = append(parts, "--exclude "+e)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,544 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/matroidbe/pgmigrate/internal/config"
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/output"
+	"github.com/matroidbe/pgmigrate/internal/schema"
+	"github.com/spf13/cobra"
+)
+
+var (
+	targetsFile     string
+	fleetParallel   int
+	continueOnError bool
+)
+
+// Target statuses in a fleet report
+const (
+	targetChanged   = "changed"
+	targetDryRun    = "dry_run"
+	targetUnchanged = "unchanged"
+	targetPending   = "pending"
+	targetSkipped   = "skipped"
+	targetFailed    = "failed"
+)
+
+// noSafeChanges is the reason a target with nothing to apply is skipped
+const noSafeChanges = "no safe changes to apply"
+
+// addFleetFlags registers --targets and its options on a command
+func addFleetFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&targetsFile, "targets", "",
+		"Run against every database listed in this targets file")
+	cmd.Flags().IntVar(&fleetParallel, "parallel", 4,
+		"With --targets, how many databases to work on at once")
+	cmd.Flags().BoolVar(&continueOnError, "continue-on-error", false,
+		"With --targets or --tenants, keep going after a database or tenant fails")
+}
+
+// targetReport is the outcome for one database of a fleet, or one tenant
+// schema
+type targetReport struct {
+	Name      string           `json:"name"`
+	Status    string           `json:"status"`
+	Plan      *db.PlanResult   `json:"plan,omitempty"`
+	Result    *db.ApplyResult  `json:"result,omitempty"`
+	DryRun    *db.DryRunResult `json:"dry_run,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	ErrorCode string           `json:"error_code,omitempty"`
+
+	target     config.Target
+	conn       *pgx.Conn
+	shared     bool
+	locked     bool
+	schemaOpts *schema.Options
+
+	// yaml is the schema planned for this target, stored on apply
+	yaml []byte
+}
+
+// fail records err as the outcome of the target
+func (r *targetReport) fail(err error) {
+	r.Status, r.Error, r.ErrorCode = targetFailed, err.Error(), errorCode(err)
+}
+
+// close releases the lock and connection of the target, if any. A shared
+// connection is left to its owner.
+func (r *targetReport) close() {
+	if r.conn == nil || r.shared {
+		return
+	}
+	if r.locked {
+		db.ReleaseApplyLock(context.Background(), r.conn)
+	}
+	db.Close(r.conn)
+	r.conn = nil
+}
+
+// fleetReport is the JSON data of plan and apply with --targets or
+// --tenants
+type fleetReport struct {
+	TargetsFile string          `json:"targets_file,omitempty"`
+	Template    string          `json:"template,omitempty"`
+	Targets     []*targetReport `json:"targets"`
+	Summary     map[string]int  `json:"summary"`
+
+	// Changes counts the targets needing each change, for --tenants
+	Changes []changeCount `json:"changes,omitempty"`
+
+	// noun names the targets in messages, confirm is typed to confirm
+	// breaking changes
+	noun    string
+	confirm string
+
+	// shown is set once the change matrix has been printed
+	shown bool
+}
+
+func newFleetReport(targets []config.Target) *fleetReport {
+	report := &fleetReport{TargetsFile: targetsFile, Summary: map[string]int{},
+		noun: "database", confirm: filepath.Base(targetsFile)}
+	for _, t := range targets {
+		report.Targets = append(report.Targets, &targetReport{Name: t.Name, target: t})
+	}
+	return report
+}
+
+// summarize counts the targets by status
+func (f *fleetReport) summarize() {
+	f.Summary = map[string]int{}
+	for _, r := range f.Targets {
+		f.Summary[r.Status]++
+	}
+}
+
+// loadTargets reads --targets and checks the flags that go with it
+func loadTargets() ([]config.Target, error) {
+	if fleetParallel < 1 {
+		return nil, withCode(codeUsage, fmt.Errorf("--parallel must be at least 1"))
+	}
+	if getDatabaseURL() != "" {
+		return nil, withCode(codeUsage, fmt.Errorf("--targets cannot be combined with --database-url or an --env URL"))
+	}
+	targets, err := config.LoadTargets(targetsFile)
+	if err != nil {
+		return nil, withCode(codeFile, err)
+	}
+
+	// Load the project config once, before any goroutine needs it
+	if _, err := loadConfig(); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// forEachTarget runs fn for the targets, at most limit at once. fn sees
+// only targets that have not failed yet. Unless --continue-on-error is set,
+// targets not started after a failure are marked skipped.
+func forEachTarget(reports []*targetReport, limit int, fn func(r *targetReport) error) {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+	)
+	sem := make(chan struct{}, limit)
+
+	for _, r := range reports {
+		if r.Status == targetFailed || r.Status == targetSkipped {
+			continue
+		}
+
+		sem <- struct{}{}
+		mu.Lock()
+		stop := failed && !continueOnError
+		mu.Unlock()
+		if stop {
+			<-sem
+			r.Status, r.Error = targetSkipped, "not run: an earlier one failed"
+			continue
+		}
+
+		wg.Add(1)
+		go func(r *targetReport) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(r); err != nil {
+				r.fail(err)
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}(r)
+	}
+	wg.Wait()
+}
+
+// planTarget connects to a target and plans the schema against it. With
+// lock set it takes the apply lock and keeps the connection open.
+func planTarget(ctx context.Context, r *targetReport, command, schemaFile string, yamlContent []byte, lock bool) error {
+	conn, err := dial(ctx, r.target.URL, "target "+r.Name, r.Name)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	if err := db.CheckExtension(ctx, conn); err != nil {
+		return err
+	}
+
+	if lock {
+		if err := db.AcquireApplyLock(ctx, conn, lockWait); err != nil {
+			return err
+		}
+		r.locked = true
+	}
+
+	if err := runHooks(ctx, conn, hookPayload{Hook: hookBeforePlan, Command: command,
+		SchemaFile: schemaFile, Target: r.Name}); err != nil {
+		return err
+	}
+
+	plan, opts, err := planSchema(ctx, conn, schemaFile, yamlContent)
+	if err != nil {
+		return err
+	}
+	if plan, err = filterPlan(plan); err != nil {
+		return err
+	}
+	r.Plan, r.schemaOpts, r.yaml = plan, opts, yamlContent
+
+	r.Status = targetPending
+	if plan.IsEmpty() {
+		r.Status = targetUnchanged
+	}
+	return nil
+}
+
+// runFleetPlan plans the schema against every target
+func runFleetPlan(ctx context.Context, schemaFile string, yamlContent []byte) error {
+	targets, err := loadTargets()
+	if err != nil {
+		return err
+	}
+
+	report := newFleetReport(targets)
+	forEachTarget(report.Targets, fleetParallel, func(r *targetReport) error {
+		defer r.close()
+		return planTarget(ctx, r, "plan", schemaFile, yamlContent, false)
+	})
+
+	return finishFleetReport(report)
+}
+
+// runFleetApply plans the schema against every target, asks once, and
+// applies it to each target that has changes. Each target is applied in
+// its own transaction under its own apply lock.
+func runFleetApply(cmd *cobra.Command, schemaFile string, yamlContent []byte) error {
+	ctx := cmd.Context()
+
+	if applyInteractive {
+		return withCode(codeUsage, fmt.Errorf("--interactive cannot be combined with --targets"))
+	}
+	if applyEvents != "" {
+		return withCode(codeUsage, fmt.Errorf("--events cannot be combined with --targets"))
+	}
+
+	targets, err := loadTargets()
+	if err != nil {
+		return err
+	}
+	archive, err := archiveEnabled(cmd)
+	if err != nil {
+		return err
+	}
+
+	report := newFleetReport(targets)
+	defer func() {
+		for _, r := range report.Targets {
+			r.close()
+		}
+	}()
+
+	// Plan every target and hold its lock until it has been applied
+	forEachTarget(report.Targets, fleetParallel, func(r *targetReport) error {
+		if err := planTarget(ctx, r, "apply", schemaFile, yamlContent, true); err != nil {
+			return err
+		}
+		return checkTarget(ctx, r)
+	})
+
+	return applyFleet(ctx, report, schemaFile, archive, fleetParallel, "pgmigrate apply --targets")
+}
+
+// checkTarget applies the single-apply gates to a planned target: breaking
+// changes need --allow-breaking, protected objects are never dropped, and a
+// target with nothing the flags allow is skipped
+func checkTarget(ctx context.Context, r *targetReport) error {
+	if r.Plan.HasBreaking() && !allowBreaking {
+		return withCode(codeBreakingChanges, fmt.Errorf("breaking changes require --allow-breaking"))
+	}
+	if err := checkProtected(ctx, r.conn, r.Plan, r.schemaOpts); err != nil {
+		return err
+	}
+	if r.Status == targetPending && len(changesToApply(r.Plan, allowBreaking))+len(r.Plan.DataMigrations) == 0 {
+		r.Status, r.Error = targetSkipped, noSafeChanges
+	}
+	return nil
+}
+
+// applyFleet shows the planned targets, asks once, and applies each target
+// with changes in its own transaction, at most limit at once
+func applyFleet(ctx context.Context, report *fleetReport, schemaFile string, archive bool, limit int, reason string) error {
+	pending, breaking, failed := 0, false, false
+	for _, r := range report.Targets {
+		switch r.Status {
+		case targetPending:
+			pending++
+			breaking = breaking || r.Plan.HasBreaking()
+		case targetFailed:
+			failed = true
+		}
+	}
+
+	// A failed plan stops the whole apply unless --continue-on-error
+	if failed && !continueOnError {
+		for _, r := range report.Targets {
+			if r.Status == targetPending {
+				r.Status, r.Error = targetSkipped, "not applied: planning failed on another "+report.noun
+			}
+		}
+		return finishFleetReport(report)
+	}
+	if pending == 0 {
+		rememberUnchanged(ctx, report)
+		return finishFleetReport(report)
+	}
+
+	if !jsonOutput() {
+		printFleetTable(report)
+		fmt.Println()
+		skipped := 0
+		for _, r := range pendingTargets(report) {
+			skipped += r.Plan.DestructiveCount() - countAllowedDestructive(r.Plan)
+		}
+		if skipped > 0 {
+			output.PrintWarning(fmt.Sprintf("%d destructive change(s) will be skipped.", skipped))
+			fmt.Println("Use --allow-destructive or --allow-destructive-on to include them.")
+			fmt.Println()
+		}
+	}
+
+	if applyDryRun {
+		forEachTarget(pendingTargets(report), limit, func(r *targetReport) error {
+			result, err := db.DryRun(ctx, r.conn, r.Plan, destructiveEnabled(), fleetApplyOptions(archive, reason+" --dry-run"))
+			r.DryRun = result
+			if err != nil {
+				return err
+			}
+			if result.Failed > 0 {
+				return withCode(codeDryRunFailed,
+					fmt.Errorf("dry run: %d of %d statement(s) failed", result.Failed, len(result.Statements)))
+			}
+			r.Status = targetDryRun
+			return nil
+		})
+		return finishFleetReport(report)
+	}
+
+	if !autoApprove {
+		if jsonOutput() {
+			return withCode(codeConfirmationRequired,
+				fmt.Errorf("apply with --output json needs --auto-approve"))
+		}
+		confirmed := false
+		if breaking {
+			confirmed = output.TypedConfirmPrompt(
+				fmt.Sprintf("This applies breaking changes to %d %s(s).", pending, report.noun), report.confirm)
+		} else {
+			confirmed = output.ConfirmPrompt(fmt.Sprintf("Do you want to apply these changes to %d %s(s)?", pending, report.noun))
+		}
+		if !confirmed {
+			fmt.Println("Apply cancelled.")
+			return nil
+		}
+	}
+	rememberUnchanged(ctx, report)
+
+	forEachTarget(pendingTargets(report), limit, func(r *targetReport) error {
+		defer r.close()
+		return applyTarget(ctx, r, schemaFile, archive, reason)
+	})
+	return finishFleetReport(report)
+}
+
+// rememberUnchanged records the protected objects of the targets with
+// nothing to apply, as a single apply does for an empty plan
+func rememberUnchanged(ctx context.Context, report *fleetReport) {
+	for _, r := range report.Targets {
+		if r.Status != targetUnchanged && (r.Status != targetSkipped || r.Error != noSafeChanges) {
+			continue
+		}
+		if err := rememberProtected(ctx, r.conn, r.schemaOpts); err != nil {
+			r.fail(err)
+		}
+	}
+}
+
+// pendingTargets returns the targets with changes still to apply
+func pendingTargets(report *fleetReport) []*targetReport {
+	var pending []*targetReport
+	for _, r := range report.Targets {
+		if r.Status == targetPending {
+			pending = append(pending, r)
+		}
+	}
+	return pending
+}
+
+// fleetApplyOptions builds executor options for a target. Progress is not
+// shown, since targets may run side by side.
+func fleetApplyOptions(archive bool, reason string) db.ApplyOptions {
+	opts := db.ApplyOptions{
+		Reason:            reason,
+		DestructiveFilter: destructiveAllowed,
+		Archive:           archive,
+		LockTimeout:       lockTimeout,
+		StatementTimeout:  statementTimeout,
+		Retries:           applyRetries,
+		RetryBackoff:      retryBackoff,
+	}
+	if !changeFilter().IsEmpty() {
+		opts.Reason += " " + filterDescription()
+	}
+	if allowBreaking {
+		opts.BreakingReason = breakingReason
+	}
+	return opts
+}
+
+// applyTarget applies a planned target, running its hooks and recording
+// its schema like a single apply
+func applyTarget(ctx context.Context, r *targetReport, schemaFile string, archive bool, reason string) error {
+	hook := hookPayload{Hook: hookBeforeApply, Command: "apply", SchemaFile: schemaFile,
+		Target: r.Name, Changes: changesToApply(r.Plan, allowBreaking)}
+	if err := runHooks(ctx, r.conn, hook); err != nil {
+		return err
+	}
+
+	lastHistoryID, err := db.LatestHistoryID(ctx, r.conn)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Apply(ctx, r.conn, r.Plan, destructiveEnabled(), fleetApplyOptions(archive, reason))
+	if err != nil {
+		r.Result = result
+		hook.Hook, hook.Result, hook.Error = hookOnFailure, result, err.Error()
+		hookErr := runHooks(ctx, r.conn, hook)
+		notifyOutcome(ctx, r.conn, hook, nil)
+		if hookErr != nil {
+			return fmt.Errorf("%w; %v", err, hookErr)
+		}
+		return err
+	}
+	notifyOutcome(ctx, r.conn, hookPayload{Command: "apply", SchemaFile: schemaFile, Target: r.Name, Result: result}, nil)
+	r.Result, r.Status = result, targetChanged
+	if len(result.Applied) == 0 && len(result.DataMigrations) == 0 {
+		r.Status = targetUnchanged
+	}
+
+	if err := rememberProtected(ctx, r.conn, r.schemaOpts); err != nil {
+		return fmt.Errorf("changes were applied, but %w", err)
+	}
+	if changeFilter().IsEmpty() {
+		if err := db.RecordSchemaSnapshot(ctx, r.conn, lastHistoryID, schemaFile, r.yaml); err != nil {
+			return fmt.Errorf("changes were applied, but %w", err)
+		}
+	}
+
+	hook.Hook, hook.Result = hookAfterApply, result
+	if err := runHooks(ctx, r.conn, hook); err != nil {
+		return fmt.Errorf("changes were applied, but %w", err)
+	}
+	return nil
+}
+
+// finishFleetReport prints the final report. It fails if any target failed.
+func finishFleetReport(report *fleetReport) error {
+	report.summarize()
+	if !jsonOutput() {
+		printFleetTable(report)
+	}
+
+	if n := report.Summary[targetFailed]; n > 0 {
+		return withData(codeTargetsFailed,
+			fmt.Errorf("%d of %d %s(s) failed", n, len(report.Targets), report.noun), report)
+	}
+	if jsonOutput() {
+		return output.PrintEnvelope(currentCommand, report)
+	}
+	return nil
+}
+
+// printFleetTable prints one line per target with its change counts and
+// status, followed by the errors. For tenants the change matrix comes first.
+func printFleetTable(report *fleetReport) {
+	if !report.shown {
+		printChangeMatrix(report)
+		report.shown = true
+	}
+
+	fmt.Printf("%-24s %6s %12s %9s  %s\n", strings.ToUpper(report.noun), "SAFE", "DESTRUCTIVE", "BREAKING", "STATUS")
+	fmt.Println(strings.Repeat("-", 70))
+
+	for _, r := range report.Targets {
+		safe, destructive, breaking := "-", "-", "-"
+		if r.Plan != nil {
+			safe = fmt.Sprint(r.Plan.SafeCount())
+			destructive = fmt.Sprint(r.Plan.DestructiveCount())
+			breaking = fmt.Sprint(len(r.Plan.Breaking))
+		}
+		status := fleetStatus(r.Status)
+		if r.Status == targetSkipped && r.Error != "" {
+			status += output.Faint(" (" + r.Error + ")")
+		}
+		fmt.Printf("%-24s %6s %12s %9s  %s\n", r.Name, safe, destructive, breaking, status)
+	}
+
+	for _, r := range report.Targets {
+		if r.Error != "" && r.Status == targetFailed {
+			fmt.Println()
+			output.PrintError(fmt.Sprintf("%s: %s", r.Name, r.Error))
+		}
+	}
+
+	if len(report.Summary) > 0 {
+		parts := []string{}
+		for _, status := range []string{targetChanged, targetDryRun, targetUnchanged, targetPending, targetSkipped, targetFailed} {
+			if n := report.Summary[status]; n > 0 {
+				parts = append(parts, fmt.Sprintf("%d %s", n, status))
+			}
+		}
+		fmt.Printf("\n%d %s(s): %s\n", len(report.Targets), report.noun, strings.Join(parts, ", "))
+	}
+}
+
+// fleetStatus colors a target status
+func fleetStatus(status string) string {
+	switch status {
+	case targetChanged, targetDryRun:
+		return output.Green(status)
+	case targetFailed:
+		return output.Red(status)
+	case targetSkipped, targetPending:
+		return output.Yellow(status)
+	}
+	return status
+}
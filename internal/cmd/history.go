@@ -28,24 +28,28 @@ func init() {
 }
 
 func runHistory(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
 	// Connect to database
-	conn, err := db.Connect(getDatabaseURL())
+	conn, err := connect(ctx)
 	if err != nil {
 		return err
 	}
-	defer conn.Close(cmd.Context())
-
-	// Check extension
-	if err := db.CheckExtension(conn); err != nil {
-		return err
-	}
+	defer db.Close(conn)
 
 	// Get history
-	entries, err := db.GetHistory(conn, historyLimit)
+	entries, err := db.GetHistory(ctx, conn, historyLimit)
 	if err != nil {
 		return err
 	}
 
+	if jsonOutput() {
+		if entries == nil {
+			entries = []db.HistoryEntry{}
+		}
+		return output.PrintEnvelope("history", map[string]interface{}{"entries": entries})
+	}
+
 	output.PrintHistoryTable(entries)
 	return nil
 }
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/matroidbe/pgmigrate/internal/config"
+	"github.com/matroidbe/pgmigrate/internal/db"
+)
+
+// Hook stages
+const (
+	hookBeforePlan  = "before_plan"
+	hookBeforeApply = "before_apply"
+	hookAfterApply  = "after_apply"
+	hookOnFailure   = "on_failure"
+)
+
+// hookConnectTimeout bounds reconnecting for SQL hooks after an interrupted
+// apply closed its connection
+const hookConnectTimeout = 10 * time.Second
+
+// hookPayload is passed to hooks as JSON: on stdin for commands, and in the
+// pgmigrate.hook_payload setting for SQL files
+type hookPayload struct {
+	Hook       string          `json:"hook"`
+	Command    string          `json:"command"`
+	SchemaFile string          `json:"schema_file"`
+	Target     string          `json:"target,omitempty"`
+	Changes    []db.Change     `json:"changes"`
+	Result     *db.ApplyResult `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// runHooks runs the hooks configured for payload.Hook in order. The first
+// failing hook stops the rest and its error is returned.
+func runHooks(ctx context.Context, conn *pgx.Conn, payload hookPayload) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	var hooks []config.Hook
+	switch payload.Hook {
+	case hookBeforePlan:
+		hooks = cfg.Hooks.BeforePlan
+	case hookBeforeApply:
+		hooks = cfg.Hooks.BeforeApply
+	case hookAfterApply:
+		hooks = cfg.Hooks.AfterApply
+	case hookOnFailure:
+		hooks = cfg.Hooks.OnFailure
+		// on_failure hooks also run after Ctrl-C or --timeout
+		ctx = context.WithoutCancel(ctx)
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	if payload.Changes == nil {
+		payload.Changes = []db.Change{}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hooks {
+		if h.SQL != "" && conn.IsClosed() {
+			// An interrupted apply has closed its connection; SQL hooks
+			// run on a new one with the same settings
+			fresh, err := reconnect(ctx, conn)
+			if err != nil {
+				return withCode(codeHookFailed, fmt.Errorf("%s hook %q failed: %w", payload.Hook, h.SQL, err))
+			}
+			defer db.Close(fresh)
+			conn = fresh
+		}
+
+		name := h.Command
+		if h.SQL != "" {
+			name = h.SQL
+		}
+		if isVerbose() {
+			fmt.Fprintf(os.Stderr, "Running %s hook: %s\n", payload.Hook, name)
+		}
+
+		if h.SQL != "" {
+			err = runSQLHook(ctx, conn, cfg.Path(h.SQL), data)
+		} else {
+			err = runCommandHook(cfg.Dir, h.Command, payload, data)
+		}
+		if err != nil {
+			return withCode(codeHookFailed, fmt.Errorf("%s hook %q failed: %w", payload.Hook, name, err))
+		}
+	}
+	return nil
+}
+
+// reconnect opens a new connection with the configuration of conn
+func reconnect(ctx context.Context, conn *pgx.Conn) (*pgx.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, hookConnectTimeout)
+	defer cancel()
+	fresh, err := pgx.ConnectConfig(ctx, conn.Config())
+	if err != nil {
+		return nil, fmt.Errorf("cannot reconnect: %w", err)
+	}
+	return fresh, nil
+}
+
+// runSQLHook executes a SQL file on the connection, so settings such as
+// SET ROLE carry over to the apply
+func runSQLHook(ctx context.Context, conn *pgx.Conn, path string, payload []byte) error {
+	sql, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.Exec(ctx, "SELECT set_config('pgmigrate.hook_payload', $1, false)", string(payload)); err != nil {
+		return err
+	}
+	_, err = conn.Exec(ctx, string(sql))
+	return err
+}
+
+// runCommandHook runs a shell command in the config directory. Its output
+// goes to stderr so it cannot corrupt JSON output on stdout.
+func runCommandHook(dir, command string, payload hookPayload, data []byte) error {
+	keys := make([]string, len(payload.Changes))
+	for i, c := range payload.Changes {
+		keys[i] = c.Key()
+	}
+	changes, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"PGMIGRATE_HOOK="+payload.Hook,
+		"PGMIGRATE_COMMAND="+payload.Command,
+		"PGMIGRATE_SCHEMA_FILE="+payload.SchemaFile,
+		"PGMIGRATE_CHANGE_COUNT="+strconv.Itoa(len(payload.Changes)),
+		"PGMIGRATE_CHANGES="+string(changes),
+	)
+	if payload.Target != "" {
+		cmd.Env = append(cmd.Env, "PGMIGRATE_TARGET="+payload.Target)
+	}
+	if payload.Error != "" {
+		cmd.Env = append(cmd.Env, "PGMIGRATE_ERROR="+payload.Error)
+	}
+
+	return cmd.Run()
+}
@@ -20,7 +20,8 @@ var initCmd = &cobra.Command{
 
 If a schema already exists in the database, consider using 'pgmigrate dump'
 to generate a starting point instead.`,
-	RunE: runInit,
+	Annotations: map[string]string{"config": "optional"},
+	RunE:        runInit,
 }
 
 func init() {
@@ -33,12 +34,16 @@ func runInit(cmd *cobra.Command, args []string) error {
 
 	// Check if file exists
 	if _, err := os.Stat(filename); err == nil && !initForce {
-		return fmt.Errorf("schema.yaml already exists. Use --force to overwrite")
+		return withCode(codeFile, fmt.Errorf("schema.yaml already exists. Use --force to overwrite"))
 	}
 
 	// Write template
 	if err := os.WriteFile(filename, []byte(schema.DefaultTemplate), 0644); err != nil {
-		return fmt.Errorf("failed to create schema.yaml: %w", err)
+		return withCode(codeFile, fmt.Errorf("failed to create schema.yaml: %w", err))
+	}
+
+	if jsonOutput() {
+		return output.PrintEnvelope("init", map[string]string{"file": filename})
 	}
 
 	output.PrintSuccess("Created schema.yaml")
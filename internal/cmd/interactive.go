@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/output"
+)
+
+// chooseChanges walks through the changes apply would execute and asks
+// about each one: yes, no, skip (this and all remaining) or quit. It
+// returns the plan reduced to the approved changes and the declined ones,
+// or a nil plan if the user quit.
+func chooseChanges(plan *db.PlanResult, applyBreaking bool) (*db.PlanResult, []db.Change, error) {
+	candidates := changesToApply(plan, applyBreaking)
+	offered := map[string]bool{}
+	approved := map[string]bool{}
+	var declined []db.Change
+
+	skipRest := false
+	for i, c := range candidates {
+		offered[c.Key()] = true
+		if skipRest {
+			declined = append(declined, c)
+			continue
+		}
+
+		fmt.Printf("%s %s %s\n", output.Faint(fmt.Sprintf("[%d/%d]", i+1, len(candidates))),
+			safetyLabel(c.Safety), output.Bold(c.Key()))
+		if err := output.PrintChangeSQL([]db.Change{c}); err != nil {
+			return nil, nil, err
+		}
+
+		switch output.ChoicePrompt("Apply this change?", []string{"yes", "no", "skip", "quit"}) {
+		case "y":
+			approved[c.Key()] = true
+		case "n":
+			declined = append(declined, c)
+		case "s":
+			declined = append(declined, c)
+			skipRest = true
+		case "q":
+			return nil, nil, nil
+		}
+		fmt.Println()
+	}
+
+	// Changes that were not offered are left to the usual apply rules
+	selected, err := plan.Select(func(c db.Change) bool {
+		return !offered[c.Key()] || approved[c.Key()]
+	})
+	if err != nil {
+		var depErr *db.DependencyError
+		if errors.As(err, &depErr) {
+			return nil, nil, fmt.Errorf("%w. Approve both or decline both", err)
+		}
+		return nil, nil, err
+	}
+
+	return selected, declined, nil
+}
+
+// safetyLabel colors a change's safety level
+func safetyLabel(safety string) string {
+	switch safety {
+	case "destructive":
+		return output.Red(safety)
+	case "breaking":
+		return output.Yellow(safety)
+	}
+	return output.Green(safety)
+}
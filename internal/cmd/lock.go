@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Inspect the apply lock",
+	Long: `pgmigrate apply holds a Postgres advisory lock for the whole plan and
+apply, so two deploys cannot migrate the same database at once.
+
+Examples:
+  pgmigrate lock status       # Show who holds the lock`,
+}
+
+var lockStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show which session holds the apply lock",
+	Long: `Shows the session holding the apply lock, with its pid,
+application_name, user and start time from pg_stat_activity.`,
+	Args: cobra.NoArgs,
+	RunE: runLockStatus,
+}
+
+func init() {
+	lockCmd.AddCommand(lockStatusCmd)
+}
+
+func runLockStatus(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	// Connect to database
+	conn, err := dial(ctx, getDatabaseURL(), databaseURLSource(), "database")
+	if err != nil {
+		return err
+	}
+	defer db.Close(conn)
+
+	holder, err := db.GetApplyLockHolder(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return output.PrintEnvelope("lock status", map[string]interface{}{
+			"locked": holder != nil,
+			"holder": holder,
+		})
+	}
+
+	if holder == nil {
+		fmt.Println(output.Green("Unlocked.") + " No pgmigrate apply is running.")
+		return nil
+	}
+
+	fmt.Println(output.Yellow("Locked.") + " A pgmigrate apply is running:")
+	fmt.Println()
+	fmt.Printf("  %-18s %d\n", "pid", holder.PID)
+	fmt.Printf("  %-18s %s\n", "application_name", holder.ApplicationName)
+	fmt.Printf("  %-18s %s\n", "user", holder.User)
+	if holder.ClientAddr != "" {
+		fmt.Printf("  %-18s %s\n", "client_addr", holder.ClientAddr)
+	}
+	fmt.Printf("  %-18s %s\n", "started_at", holder.StartedAt)
+	fmt.Printf("  %-18s %s\n", "state", holder.State)
+	return nil
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateColumnTo        string
+	migrateColumnAll       bool
+	migrateColumnRollback  bool
+	migrateColumnStatus    bool
+	migrateColumnBatchSize int
+)
+
+var migrateColumnCmd = &cobra.Command{
+	Use:   "migrate-column <schema.table.column>",
+	Short: "Change a column type online with expand/contract",
+	Long: `Changes a column's type without holding an exclusive lock for the
+whole rewrite. The migration runs in phases, each started separately:
+
+  expand    Add a shadow column <column>__pgm_new with the new type
+  sync      Add a trigger copying every write into the shadow column
+  backfill  Copy existing rows in batches, each committed on its own
+  swap      Rename the columns so the new one takes the original name
+  drop      Drop the old column
+
+Phase state is tracked in the pgmigrate_cli.column_migrations table. Each
+run executes the next phase; an interrupted backfill resumes where it
+stopped. --rollback undoes the last completed phase (except drop). DDL runs
+through pgmigrate.dba_migrate(), so every phase is recorded in history.
+
+Indexes, constraints and views on the old column are not moved by the swap.
+Recreate them on the new column before the drop phase, which refuses while
+any of them still uses the old column.
+
+Examples:
+  pgmigrate migrate-column public.users.email --to 'varchar(500)'
+  pgmigrate migrate-column public.users.email --to 'varchar(500)' --all
+  pgmigrate migrate-column public.users.email --status
+  pgmigrate migrate-column public.users.email --rollback`,
+	Args: cobra.ExactArgs(1),
+	RunE: runMigrateColumn,
+}
+
+func init() {
+	migrateColumnCmd.Flags().StringVar(&migrateColumnTo, "to", "",
+		"New column type (required to start a migration)")
+	migrateColumnCmd.Flags().BoolVar(&migrateColumnAll, "all", false,
+		"Run all remaining phases")
+	migrateColumnCmd.Flags().BoolVar(&migrateColumnRollback, "rollback", false,
+		"Roll back the last completed phase")
+	migrateColumnCmd.Flags().BoolVar(&migrateColumnStatus, "status", false,
+		"Show the migration state without changing anything")
+	migrateColumnCmd.Flags().IntVar(&migrateColumnBatchSize, "batch-size", 1000,
+		"Rows per backfill batch")
+}
+
+func runMigrateColumn(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	parts := strings.Split(args[0], ".")
+	if len(parts) != 3 {
+		return withCode(codeUsage, fmt.Errorf("expected schema.table.column, got %q", args[0]))
+	}
+	schemaName, tableName, columnName := parts[0], parts[1], parts[2]
+
+	// Connect to database
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close(conn)
+
+	if err := db.AcquireApplyLock(ctx, conn, 0); err != nil {
+		return err
+	}
+	defer db.ReleaseApplyLock(ctx, conn)
+
+	m, err := db.GetColumnMigration(ctx, conn, schemaName, tableName, columnName)
+	if err != nil {
+		return err
+	}
+
+	if migrateColumnStatus {
+		return printColumnMigration(m)
+	}
+
+	if migrateColumnRollback {
+		if m == nil {
+			return fmt.Errorf("no migration in progress for %s", args[0])
+		}
+		undone := m.Phase
+		if err := db.RollbackColumnMigrationPhase(ctx, conn, m); err != nil {
+			return err
+		}
+		if !jsonOutput() {
+			output.PrintSuccess(fmt.Sprintf("Rolled back phase %s.", undone))
+		}
+		return printColumnMigration(m)
+	}
+
+	// A finished migration does not block a new one
+	if m != nil && m.NextPhase() == "" && migrateColumnTo != "" {
+		m = nil
+	}
+
+	if m == nil {
+		if migrateColumnTo == "" {
+			return withCode(codeUsage, fmt.Errorf("--to is required to start a migration"))
+		}
+		if m, err = db.StartColumnMigration(ctx, conn, schemaName, tableName, columnName, migrateColumnTo); err != nil {
+			return err
+		}
+	} else if migrateColumnTo != "" && migrateColumnTo != m.ToType {
+		return fmt.Errorf("a migration of %s to %s is already in progress; roll it back first",
+			args[0], m.ToType)
+	}
+
+	for {
+		phase := m.NextPhase()
+		if phase == "" {
+			break
+		}
+
+		if !jsonOutput() {
+			fmt.Printf("Running phase %s...\n", output.Bold(phase))
+		}
+		onBatch := func(rows int64) {
+			if !jsonOutput() {
+				fmt.Printf("  backfilled %d rows\n", rows)
+			}
+		}
+		if err := db.RunColumnMigrationPhase(ctx, conn, m, migrateColumnBatchSize, onBatch); err != nil {
+			return err
+		}
+
+		if !migrateColumnAll {
+			break
+		}
+	}
+
+	return printColumnMigration(m)
+}
+
+// printColumnMigration shows the phases of a migration and which are done
+func printColumnMigration(m *db.ColumnMigration) error {
+	if jsonOutput() {
+		return output.PrintEnvelope("migrate-column", map[string]interface{}{"migration": m})
+	}
+
+	if m == nil {
+		fmt.Println("No migration in progress.")
+		return nil
+	}
+
+	fmt.Println()
+	fmt.Printf("%s.%s.%s: %s -> %s\n", m.Schema, m.Table, m.Column, m.FromType, output.Bold(m.ToType))
+	done := m.Phase != ""
+	for _, phase := range db.ColumnMigrationPhases {
+		status := output.Faint("pending")
+		if done {
+			status = output.Green("done")
+		}
+		if phase == db.PhaseBackfill && m.Backfilled > 0 {
+			status += output.Faint(fmt.Sprintf(" (%d rows)", m.Backfilled))
+		}
+		fmt.Printf("  %-10s %s\n", phase, status)
+		if phase == m.Phase {
+			done = false
+		}
+	}
+
+	if next := m.NextPhase(); next != "" {
+		fmt.Printf("\nNext phase: %s\n", next)
+	} else {
+		fmt.Println()
+		output.PrintSuccess("Migration complete.")
+	}
+	return nil
+}
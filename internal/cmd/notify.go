@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/matroidbe/pgmigrate/internal/config"
+	"github.com/matroidbe/pgmigrate/internal/db"
+)
+
+// notifyTimeout bounds each webhook request
+const notifyTimeout = 10 * time.Second
+
+// notification is the JSON body of a webhook, and the content of an email
+type notification struct {
+	Event          string   `json:"event"`
+	Command        string   `json:"command"`
+	Database       string   `json:"database"`
+	Target         string   `json:"target,omitempty"`
+	SchemaFile     string   `json:"schema_file"`
+	User           string   `json:"user"`
+	DBUser         string   `json:"db_user"`
+	Summary        string   `json:"summary"`
+	Applied        []string `json:"applied"`
+	Skipped        []string `json:"skipped"`
+	DataMigrations []string `json:"data_migrations,omitempty"`
+	DurationMs     int      `json:"duration_ms"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// notifyOutcome sends how an apply ended to the configured notifiers. The
+// hook payload carries the result or error; skipped lists the destructive
+// changes left out when nothing ran. A notifier that fails only prints a
+// warning, since the apply is over either way.
+func notifyOutcome(ctx context.Context, conn *pgx.Conn, hook hookPayload, skipped []db.Change) {
+	cfg, err := loadConfig()
+	if err != nil || len(cfg.Notify) == 0 {
+		return
+	}
+
+	n := newNotification(conn.Config().Database, conn.Config().User, hook, skipped)
+	ctx = context.WithoutCancel(ctx)
+	for i, notifier := range cfg.Notify {
+		if !notifier.Notifies(n.Event) {
+			continue
+		}
+		if isVerbose() {
+			fmt.Fprintf(os.Stderr, "Sending %s notification %d\n", n.Event, i+1)
+		}
+
+		if notifier.Webhook != nil {
+			err = sendWebhook(ctx, notifier.Webhook, n)
+		} else {
+			err = sendEmail(ctx, notifier.Email, n)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: notify[%d] failed: %v\n", i, err)
+		}
+	}
+}
+
+// newNotification describes the outcome of an apply on database, made as
+// dbUser
+func newNotification(database, dbUser string, hook hookPayload, skipped []db.Change) notification {
+	n := notification{
+		Event:      config.EventSuccess,
+		Command:    hook.Command,
+		Database:   database,
+		Target:     hook.Target,
+		SchemaFile: hook.SchemaFile,
+		User:       osUser(),
+		DBUser:     dbUser,
+		Applied:    []string{},
+		Error:      hook.Error,
+	}
+	if r := hook.Result; r != nil {
+		for _, c := range r.Applied {
+			n.Applied = append(n.Applied, c.Key())
+		}
+		n.DataMigrations, n.DurationMs = r.DataMigrations, r.DurationMs
+		if hook.Error == "" {
+			skipped = append(skipped, r.Skipped...)
+		}
+	}
+	n.Skipped = make([]string, len(skipped))
+	for i, c := range skipped {
+		n.Skipped[i] = c.Key()
+	}
+
+	where := n.Database
+	if n.Target != "" {
+		where = fmt.Sprintf("%s (%s)", n.Target, n.Database)
+	}
+	applied := len(n.Applied) + len(n.DataMigrations)
+
+	switch {
+	case hook.Error != "":
+		n.Event = config.EventFailure
+		n.Summary = fmt.Sprintf("pgmigrate %s on %s failed after %d committed change(s): %s",
+			n.Command, where, applied, hook.Error)
+	case len(n.Skipped) > 0:
+		n.Event = config.EventSkippedDestructive
+		n.Summary = fmt.Sprintf("pgmigrate %s on %s: %d change(s) applied, %d destructive change(s) skipped",
+			n.Command, where, applied, len(n.Skipped))
+	default:
+		n.Summary = fmt.Sprintf("pgmigrate %s on %s: %d change(s) applied in %s",
+			n.Command, where, applied, time.Duration(n.DurationMs)*time.Millisecond)
+	}
+	return n
+}
+
+// osUser returns the name of the user running pgmigrate
+func osUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// sendWebhook posts the notification as JSON. Header values may reference
+// environment variables as $NAME.
+func sendWebhook(ctx context.Context, w *config.Webhook, n notification) error {
+	url := w.Endpoint()
+	if url == "" {
+		return fmt.Errorf("webhook url_env %s is not set", w.URLEnv)
+	}
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sendEmail sends the notification as a plain text email
+func sendEmail(ctx context.Context, m *config.Email, n notification) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", m.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(m.To, ", "))
+	// The summary can carry database error text, so it must not be able to
+	// end the header
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(n.Summary)
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&body, "%s\r\n\r\n", n.Summary)
+	fmt.Fprintf(&body, "Database:    %s\r\n", n.Database)
+	if n.Target != "" {
+		fmt.Fprintf(&body, "Target:      %s\r\n", n.Target)
+	}
+	fmt.Fprintf(&body, "Schema file: %s\r\n", n.SchemaFile)
+	fmt.Fprintf(&body, "User:        %s (database user %s)\r\n", n.User, n.DBUser)
+	fmt.Fprintf(&body, "Duration:    %s\r\n", time.Duration(n.DurationMs)*time.Millisecond)
+
+	sections := []struct {
+		title string
+		items []string
+	}{
+		{"Applied", n.Applied},
+		{"Data migrations", n.DataMigrations},
+		{"Skipped destructive changes", n.Skipped},
+	}
+	for _, s := range sections {
+		if len(s.items) == 0 {
+			continue
+		}
+		fmt.Fprintf(&body, "\r\n%s:\r\n", s.title)
+		for _, item := range s.items {
+			fmt.Fprintf(&body, "  %s\r\n", item)
+		}
+	}
+
+	return sendMail(ctx, m, []byte(body.String()))
+}
+
+// sendMail delivers a message like smtp.SendMail, with the whole exchange
+// bounded by notifyTimeout so a hung server cannot hold up the apply
+func sendMail(ctx context.Context, m *config.Email, msg []byte) error {
+	host, _, err := net.SplitHostPort(m.SMTP)
+	if err != nil {
+		return fmt.Errorf("invalid smtp address %q: %w", m.SMTP, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", m.SMTP)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.Username, os.Getenv(m.PasswordEnv), host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(m.From); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
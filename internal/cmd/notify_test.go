@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matroidbe/pgmigrate/internal/config"
+	"github.com/matroidbe/pgmigrate/internal/db"
+)
+
+// webhookServer records the notifications posted to it
+func webhookServer(t *testing.T) (*httptest.Server, chan notification, chan http.Header) {
+	t.Helper()
+	bodies, headers := make(chan notification, 1), make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bodies <- n
+		headers <- r.Header
+	}))
+	t.Cleanup(srv.Close)
+	return srv, bodies, headers
+}
+
+func TestWebhookPayload(t *testing.T) {
+	t.Setenv("PGMIGRATE_TEST_TOKEN", "secret")
+	srv, bodies, headers := webhookServer(t)
+	hook := &config.Webhook{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer $PGMIGRATE_TEST_TOKEN"}}
+
+	result := &db.ApplyResult{
+		Applied:    []db.Change{{Type: "create_schema", Name: "app"}},
+		DurationMs: 1500,
+	}
+	n := newNotification("shop", "deploy", hookPayload{Command: "apply", SchemaFile: "schema.yaml", Result: result}, nil)
+	if err := sendWebhook(context.Background(), hook, n); err != nil {
+		t.Fatalf("sendWebhook: %v", err)
+	}
+
+	got := <-bodies
+	if got.Event != config.EventSuccess {
+		t.Errorf("event %q, want %q", got.Event, config.EventSuccess)
+	}
+	if got.Database != "shop" || got.DBUser != "deploy" || got.SchemaFile != "schema.yaml" || got.Command != "apply" {
+		t.Errorf("unexpected payload %+v", got)
+	}
+	if len(got.Applied) != 1 || got.Applied[0] != result.Applied[0].Key() {
+		t.Errorf("applied %v, want [%s]", got.Applied, result.Applied[0].Key())
+	}
+	if got.DurationMs != 1500 {
+		t.Errorf("duration %d, want 1500", got.DurationMs)
+	}
+
+	h := <-headers
+	if h.Get("Authorization") != "Bearer secret" {
+		t.Errorf("Authorization %q, want environment variable expanded", h.Get("Authorization"))
+	}
+	if h.Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type %q", h.Get("Content-Type"))
+	}
+}
+
+func TestWebhookFailureEvent(t *testing.T) {
+	srv, bodies, _ := webhookServer(t)
+
+	n := newNotification("shop", "deploy", hookPayload{Command: "apply", Error: "lock timeout"}, nil)
+	if err := sendWebhook(context.Background(), &config.Webhook{URL: srv.URL}, n); err != nil {
+		t.Fatalf("sendWebhook: %v", err)
+	}
+
+	got := <-bodies
+	if got.Event != config.EventFailure || got.Error != "lock timeout" {
+		t.Errorf("got event %q error %q, want failure with the apply error", got.Event, got.Error)
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := sendWebhook(ctx, &config.Webhook{URL: srv.URL}, notification{Event: config.EventSuccess})
+	if err == nil {
+		t.Fatal("sendWebhook to a hung server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("sendWebhook took %s to give up", elapsed)
+	}
+}
+
+func TestEmailTimeout(t *testing.T) {
+	// A server that accepts the connection and never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	m := &config.Email{SMTP: ln.Addr().String(), From: "pgmigrate@example.com", To: []string{"dba@example.com"}}
+	if err := sendEmail(ctx, m, notification{Summary: "done"}); err == nil {
+		t.Fatal("sendEmail to a silent server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("sendEmail took %s to give up", elapsed)
+	}
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/schema"
+	"github.com/spf13/cobra"
+)
+
+var (
+	noOnlineConstraints bool
+	concurrentIndexes   bool
+)
+
+// addOnlineFlags registers the flags that control low-lock rewrites
+func addOnlineFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&noOnlineConstraints, "no-online-constraints", false,
+		"Set NOT NULL and add foreign keys in one step instead of NOT VALID + VALIDATE")
+	cmd.Flags().BoolVar(&concurrentIndexes, "concurrent-indexes", false,
+		"Create and drop every index CONCURRENTLY, outside the transaction")
+}
+
+// onlinePlan rewrites plan changes into low-lock step sequences
+func onlinePlan(plan *db.PlanResult, opts *schema.Options) {
+	plan.UseConcurrentIndexes(concurrentIndexes, opts.ConcurrentIndexes)
+	if !noOnlineConstraints {
+		plan.UseOnlineConstraints()
+	}
+}
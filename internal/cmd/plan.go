@@ -1,16 +1,19 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/matroidbe/pgmigrate/internal/db"
 	"github.com/matroidbe/pgmigrate/internal/output"
+	"github.com/matroidbe/pgmigrate/internal/schema"
 	"github.com/spf13/cobra"
 )
 
 var (
-	planOutput string
+	planFlat bool
 )
 
 var planCmd = &cobra.Command{
@@ -22,24 +25,58 @@ what changes would be made. No changes are applied.
 The plan output categorizes changes by safety level:
   + Safe:        Additive changes (auto-applied)
   - Destructive: Data loss possible (requires --allow-destructive)
-  ! Breaking:    May fail or corrupt (requires manual dba_migrate)
+  ! Breaking:    May fail or corrupt (requires --allow-breaking)
+
+Setting NOT NULL and adding a column with a foreign key are planned as safe
+changes that run in low-lock steps (see 'pgmigrate apply --help'). Indexes
+marked concurrently: true, or all of them with --concurrent-indexes, are
+built CONCURRENTLY.
+
+Scripts in the data_migrations directory next to the schema file that have
+not run yet are listed as pending data migrations.
+
+Plans with more than 30 changes are grouped by schema and table, with
+per-table safety counts. Use --flat for a single list.
+
+With -o json the plan is the data of the JSON envelope (see the README);
+older versions printed the plan object alone.
 
 Examples:
   pgmigrate plan                    # Use schema.yaml in current directory
   pgmigrate plan myschema.yaml      # Use specific file
-  pgmigrate plan -o json            # Output as JSON`,
-	Args: cobra.MaximumNArgs(1),
-	RunE: runPlan,
+  pgmigrate plan -o json            # Output as JSON envelope
+  pgmigrate plan -o sarif           # Destructive/breaking changes as SARIF
+  pgmigrate plan -o junit           # Destructive/breaking changes as JUnit XML
+  pgmigrate plan --flat             # Never group large plans by table
+  pgmigrate plan --target public.users      # Only changes on one table
+  pgmigrate plan --exclude billing          # Leave out a whole schema
+  pgmigrate plan --targets targets.yaml     # Plan every database of a fleet
+  pgmigrate plan --tenants 'tenant_*'       # Plan every tenant schema
+
+With --targets, the schema is planned against every database listed in the
+targets file, --parallel at a time, and a table of change counts per
+database is shown instead of the plans. With --tenants, the tenant_template
+schema is planned against every matching tenant schema, and a matrix of the
+changes and how many tenants need them is shown.`,
+	Args:        cobra.MaximumNArgs(1),
+	RunE:        runPlan,
+	Annotations: map[string]string{"output_formats": "sarif,junit"},
 }
 
 func init() {
-	planCmd.Flags().StringVarP(&planOutput, "output", "o", "text",
-		"Output format: text, json")
+	planCmd.Flags().BoolVar(&planFlat, "flat", false,
+		"Show a flat list instead of grouping large plans by table")
+	addFilterFlags(planCmd)
+	addOnlineFlags(planCmd)
+	addFleetFlags(planCmd)
+	addTenantFlags(planCmd)
 }
 
 func runPlan(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
 	// Determine schema file
-	schemaFile := "schema.yaml"
+	schemaFile := defaultSchemaFile()
 	if len(args) > 0 {
 		schemaFile = args[0]
 	}
@@ -47,32 +84,78 @@ func runPlan(cmd *cobra.Command, args []string) error {
 	// Read YAML content
 	yamlContent, err := os.ReadFile(schemaFile)
 	if err != nil {
-		return fmt.Errorf("cannot read %s: %w", schemaFile, err)
+		return withCode(codeFile, fmt.Errorf("cannot read %s: %w", schemaFile, err))
+	}
+
+	if tenantMode() || targetsFile != "" {
+		if outputFormat != "text" && outputFormat != "json" {
+			return withCode(codeUsage, fmt.Errorf("--targets and --tenants support --output text or json only"))
+		}
+		if tenantMode() {
+			return runTenantPlan(cmd, schemaFile, yamlContent)
+		}
+		return runFleetPlan(cmd.Context(), schemaFile, yamlContent)
 	}
 
 	// Connect to database
-	conn, err := db.Connect(getDatabaseURL())
+	conn, err := connect(ctx)
 	if err != nil {
 		return err
 	}
-	defer conn.Close(cmd.Context())
+	defer db.Close(conn)
 
-	// Check extension
-	if err := db.CheckExtension(conn); err != nil {
+	if err := runHooks(ctx, conn, hookPayload{Hook: hookBeforePlan, Command: "plan", SchemaFile: schemaFile}); err != nil {
 		return err
 	}
 
 	// Get plan
-	plan, err := db.Plan(conn, string(yamlContent))
+	plan, _, err := planSchema(ctx, conn, schemaFile, yamlContent)
+	if err != nil {
+		return err
+	}
+
+	// Restrict to --target/--exclude
+	plan, err = filterPlan(plan)
 	if err != nil {
 		return err
 	}
 
 	// Output based on format
-	if planOutput == "json" {
-		return output.PrintPlanJSON(plan)
+	switch outputFormat {
+	case "json":
+		return output.PrintEnvelope("plan", plan)
+	case "sarif":
+		return output.PrintPlanSARIF(plan, schemaFile, yamlContent, Version)
+	case "junit":
+		return output.PrintPlanJUnit(plan, schemaFile, yamlContent)
 	}
 
-	output.PrintPlanTerraform(plan)
+	output.PrintPlan(plan, planFlat)
 	return nil
 }
+
+// planSchema plans schema.yaml content against the database. Keys only
+// pgmigrate understands are removed before the schema is loaded, the plan
+// is rewritten into low-lock steps where possible, and pending data
+// migrations next to schemaFile are added, unless it is empty. The
+// pgmigrate-only options are returned as well.
+func planSchema(ctx context.Context, conn *pgx.Conn, schemaFile string, yamlContent []byte) (*db.PlanResult, *schema.Options, error) {
+	opts, loadContent, err := schema.ExtractOptions(yamlContent)
+	if err != nil {
+		return nil, nil, withCode(codeFile, err)
+	}
+
+	plan, err := db.Plan(ctx, conn, string(loadContent))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if schemaFile != "" {
+		if plan.DataMigrations, err = pendingDataMigrations(ctx, conn, schemaFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	onlinePlan(plan, opts)
+	return plan, opts, nil
+}
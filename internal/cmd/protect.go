@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/schema"
+)
+
+var (
+	allowDestructiveOn []string
+)
+
+// destructiveEnabled returns true if any destructive change may be applied
+func destructiveEnabled() bool {
+	return allowDestructive || len(allowDestructiveOn) > 0
+}
+
+// destructiveAllowed returns true if --allow-destructive or a matching
+// --allow-destructive-on permits a destructive change
+func destructiveAllowed(c db.Change) bool {
+	return allowDestructive || db.MatchAny(allowDestructiveOn, c)
+}
+
+// checkPolicyFlags refuses apply flags the project policy, or the policy of
+// the --env environment, does not allow
+func checkPolicyFlags() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	scope := "the project policy"
+	if envName != "" {
+		scope = "the policy of environment " + envName
+	}
+	p := cfg.Policy
+	switch {
+	case p.ForbidBreaking && allowBreaking:
+		return withCode(codePolicyViolation, fmt.Errorf("%s forbids --allow-breaking", scope))
+	case p.ForbidDestructive && destructiveEnabled():
+		return withCode(codePolicyViolation, fmt.Errorf("%s forbids --allow-destructive and --allow-destructive-on", scope))
+	case p.RequireInteractive && !applyInteractive && !applyDryRun:
+		return withCode(codePolicyViolation, fmt.Errorf("%s requires --interactive (or --dry-run)", scope))
+	}
+	return nil
+}
+
+// rememberProtected records the protected: flags of schema.yaml once an
+// apply has ended well, so objects stay protected after they are removed
+// from it. Dry runs record nothing.
+func rememberProtected(ctx context.Context, conn *pgx.Conn, opts *schema.Options) error {
+	if applyDryRun {
+		return nil
+	}
+	return db.SetProtectedObjects(ctx, conn, opts.Protected)
+}
+
+// checkProtected refuses an apply that would drop a protected object: one
+// listed in policy.never_drop, marked protected: true in schema.yaml, or
+// recorded as protected by an earlier apply. Dropping a schema or table also
+// drops the protected objects inside it.
+func checkProtected(ctx context.Context, conn *pgx.Conn, plan *db.PlanResult, opts *schema.Options) error {
+	if !destructiveEnabled() {
+		return nil
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	stored, err := db.GetProtectedObjects(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return checkProtectedDrops(plan, destructiveAllowed, cfg.Policy.NeverDrop, opts.Protected, stored)
+}
+
+// checkProtectedDrops returns a policy violation for the first allowed
+// destructive change that drops an object matching neverDrop, marked
+// protected in schema.yaml, or stored as protected and not explicitly
+// unprotected in marked
+func checkProtectedDrops(plan *db.PlanResult, allowed func(db.Change) bool, neverDrop []string, marked, stored map[string]bool) error {
+	for _, c := range plan.Destructive {
+		object := c.DroppedObject()
+		if object == "" || !allowed(c) {
+			continue
+		}
+
+		for _, pattern := range neverDrop {
+			if db.DropRemoves(object, pattern) {
+				return withCode(codePolicyViolation, fmt.Errorf(
+					"%s would drop %s, which policy.never_drop forbids (%s)", c.Key(), object, pattern))
+			}
+		}
+		for protected, on := range marked {
+			if on && db.DropRemoves(object, protected) {
+				return withCode(codePolicyViolation, fmt.Errorf(
+					"%s would drop %s, which is marked protected in schema.yaml", c.Key(), protected))
+			}
+		}
+		for protected := range stored {
+			// protected: false in schema.yaml lifts the protection
+			if on, explicit := marked[protected]; explicit && !on {
+				continue
+			}
+			if db.DropRemoves(object, protected) {
+				return withCode(codePolicyViolation, fmt.Errorf(
+					"%s would drop %s, which was marked protected by an earlier apply. "+
+						"Apply it with protected: false first to allow dropping it", c.Key(), protected))
+			}
+		}
+	}
+	return nil
+}
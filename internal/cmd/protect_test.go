@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/matroidbe/pgmigrate/internal/db"
+)
+
+func TestCheckProtectedDrops(t *testing.T) {
+	dropTable := db.Change{Type: "drop_table", Schema: "public", Table: "users", Safety: "destructive"}
+	dropSchema := db.Change{Type: "drop_schema", Name: "audit", Safety: "destructive"}
+	all := func(db.Change) bool { return true }
+	none := func(db.Change) bool { return false }
+
+	tests := []struct {
+		name      string
+		change    db.Change
+		allowed   func(db.Change) bool
+		neverDrop []string
+		marked    map[string]bool
+		stored    map[string]bool
+		refused   bool
+	}{
+		{name: "unprotected", change: dropTable, allowed: all},
+		{name: "never_drop", change: dropTable, allowed: all, neverDrop: []string{"public.*"}, refused: true},
+		{name: "never_drop of another schema", change: dropTable, allowed: all, neverDrop: []string{"audit.*"}},
+		{name: "marked protected", change: dropTable, allowed: all,
+			marked: map[string]bool{"public.users": true}, refused: true},
+		{name: "marked column inside dropped table", change: dropTable, allowed: all,
+			marked: map[string]bool{"public.users.email": true}, refused: true},
+		{name: "table inside dropped schema", change: dropSchema, allowed: all,
+			stored: map[string]bool{"audit.events": true}, refused: true},
+		{name: "stored protection", change: dropTable, allowed: all,
+			stored: map[string]bool{"public.users": true}, refused: true},
+		{name: "stored protection lifted", change: dropTable, allowed: all,
+			marked: map[string]bool{"public.users": false}, stored: map[string]bool{"public.users": true}},
+		{name: "change not allowed", change: dropTable, allowed: none,
+			marked: map[string]bool{"public.users": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &db.PlanResult{Destructive: []db.Change{tt.change}}
+			err := checkProtectedDrops(plan, tt.allowed, tt.neverDrop, tt.marked, tt.stored)
+			if tt.refused {
+				if err == nil {
+					t.Fatal("drop of a protected object was allowed")
+				}
+				if code := errorCode(err); code != codePolicyViolation {
+					t.Errorf("error code %q, want %q", code, codePolicyViolation)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected refusal: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/spf13/cobra"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <history-id>",
+	Short: "Return the database to a previously applied schema",
+	Long: `Plans from the live database back to the schema.yaml that was applied by
+the apply which recorded the given history entry, and applies it like
+'pgmigrate apply': the same plan output, destructive and breaking change
+flags, protection policy, confirmation, hooks and lock.
+
+Every complete apply stores its schema.yaml in pgmigrate_cli.schema_snapshots. Any
+history id of that apply selects it. Rollback refuses if no schema was
+stored for the entry, e.g. for applies made before pgmigrate kept them,
+partial applies (--target, --exclude or declined --interactive changes), or
+changes run with 'pgmigrate dba'.
+
+Rolling back usually drops what was added since, so it needs
+--allow-destructive. Data in dropped objects is lost unless --archive is
+used. Data migrations are not undone.
+
+Examples:
+  pgmigrate history                        # Find the apply to return to
+  pgmigrate rollback 42                    # Preview and apply safe changes
+  pgmigrate rollback 42 --dry-run          # Try it, then roll back
+  pgmigrate rollback 42 --allow-destructive --archive`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRollback,
+}
+
+func init() {
+	addApplyFlags(rollbackCmd)
+}
+
+func runRollback(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return withCode(codeUsage, fmt.Errorf("invalid history id %q", args[0]))
+	}
+
+	if err := validateApplyFlags(); err != nil {
+		return err
+	}
+
+	// Connect to database
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close(conn)
+
+	if err := db.AcquireApplyLock(ctx, conn, lockWait); err != nil {
+		return err
+	}
+	defer db.ReleaseApplyLock(ctx, conn)
+
+	snapshot, err := db.GetSchemaSnapshot(ctx, conn, id)
+	if err != nil {
+		return err
+	}
+	if snapshot == nil {
+		return fmt.Errorf("no applied schema stored for history entry %d; only complete applies made with this version of pgmigrate can be rolled back to", id)
+	}
+
+	if !jsonOutput() {
+		fmt.Printf("Rolling back to %s as applied by %s at %s (history %d-%d).\n\n",
+			snapshot.SchemaFile, snapshot.AppliedBy, snapshot.AppliedAt.Format("2006-01-02 15:04:05"),
+			snapshot.FirstHistoryID, snapshot.LastHistoryID)
+	}
+
+	return applySchema(cmd, conn, applySource{
+		SchemaFile: snapshot.SchemaFile,
+		YAML:       []byte(snapshot.YAML),
+		Reason:     fmt.Sprintf("pgmigrate rollback %d", id),
+		Rollback:   true,
+	})
+}
@@ -1,6 +1,18 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/matroidbe/pgmigrate/internal/config"
+	"github.com/matroidbe/pgmigrate/internal/db"
 	"github.com/matroidbe/pgmigrate/internal/output"
 	"github.com/spf13/cobra"
 )
@@ -12,9 +24,34 @@ var (
 	GitCommit = ""
 
 	// Global flags
-	databaseURL string
-	verbose     bool
-	noColor     bool
+	databaseURL  string
+	verbose      bool
+	noColor      bool
+	outputFormat string
+	configFile   string
+	envName      string
+	timeout      time.Duration
+	waitTimeout  time.Duration
+	waitExt      bool
+
+	// Credential flags
+	passwordFile    string
+	passwordCommand string
+	tlsCert         string
+	tlsKey          string
+	tlsRootCA       string
+
+	// envURL is the database URL of the --env environment
+	envURL string
+
+	// cancelTimeout releases the --timeout context
+	cancelTimeout context.CancelFunc = func() {}
+
+	// projectConfig is loaded on first use by loadConfig
+	projectConfig *config.Config
+
+	// currentCommand is the name of the running command, for JSON output
+	currentCommand = "pgmigrate"
 )
 
 var rootCmd = &cobra.Command{
@@ -36,16 +73,44 @@ Example:
 `,
 	SilenceUsage:  true,
 	SilenceErrors: true,
-	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		if noColor {
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		currentCommand = strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+		if err := useProjectConfig(cmd); err != nil && !configOptional(cmd) {
+			return err
+		}
+		if passwordFile != "" && passwordCommand != "" {
+			return withCode(codeUsage, fmt.Errorf("--password-file and --password-command cannot be combined"))
+		}
+		if noColor || jsonOutput() {
 			output.DisableColors()
 		}
+		if timeout > 0 {
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			cmd.SetContext(ctx)
+			cancelTimeout = cancel
+		}
+		return checkOutputFormat(cmd)
 	},
 }
 
-// Execute runs the root command
+// Execute runs the root command. The first SIGINT or SIGTERM cancels the
+// command's context, which cancels the running query on the server; a
+// second one kills the process.
 func Execute() error {
-	return rootCmd.Execute()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer func() { cancelTimeout() }()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		fmt.Fprintf(os.Stderr, "\nReceived %s, cancelling. Press Ctrl-C again to quit immediately.\n", sig)
+		cancel()
+	}()
+
+	return rootCmd.ExecuteContext(ctx)
 }
 
 func init() {
@@ -55,6 +120,32 @@ func init() {
 		"Enable verbose output")
 	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false,
 		"Disable colored output")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "text",
+		"Output format: text, json")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "",
+		"Project config file (default: nearest pgmigrate.yaml in this or a parent directory)")
+	rootCmd.PersistentFlags().StringVar(&envName, "env", "",
+		"Use this environment from the project config")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0,
+		"Cancel the command after this long, e.g. 10m (0 for no limit)")
+	rootCmd.PersistentFlags().DurationVar(&waitTimeout, "wait", 0,
+		"Retry connecting for up to this long while the database starts, e.g. 60s")
+	rootCmd.PersistentFlags().BoolVar(&waitExt, "wait-extension", false,
+		"With --wait, also wait until the pg_migrate extension is installed")
+	rootCmd.PersistentFlags().StringVar(&passwordFile, "password-file", "",
+		"Read the database password from this file")
+	rootCmd.PersistentFlags().StringVar(&passwordCommand, "password-command", "",
+		"Read the database password from the output of this shell command")
+	rootCmd.PersistentFlags().StringVar(&tlsCert, "tls-cert", "",
+		"TLS client certificate file (PEM)")
+	rootCmd.PersistentFlags().StringVar(&tlsKey, "tls-key", "",
+		"TLS client key file (PEM)")
+	rootCmd.PersistentFlags().StringVar(&tlsRootCA, "tls-root-ca", "",
+		"CA certificate file (PEM) to verify the server certificate with")
+
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return withCode(codeUsage, err)
+	})
 
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(planCmd)
@@ -62,14 +153,204 @@ func init() {
 	rootCmd.AddCommand(dumpCmd)
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(lockCmd)
+	rootCmd.AddCommand(dbaCmd)
+	rootCmd.AddCommand(migrateColumnCmd)
+	rootCmd.AddCommand(archiveCmd)
+	rootCmd.AddCommand(rollbackCmd)
+	rootCmd.AddCommand(envCmd)
+	rootCmd.AddCommand(waitCmd)
 }
 
-// getDatabaseURL returns the database URL from flag or environment
+// getDatabaseURL returns the database URL from --database-url or --env. An
+// empty URL makes db.Connect fall back to DATABASE_URL, then to the PG*
+// environment variables.
 func getDatabaseURL() string {
-	return databaseURL
+	if databaseURL != "" {
+		return databaseURL
+	}
+	return envURL
+}
+
+// databaseURLSource says where getDatabaseURL found the URL, for --verbose
+func databaseURLSource() string {
+	if databaseURL != "" {
+		return "--database-url"
+	}
+	if envURL != "" {
+		return "environment " + envName
+	}
+	return ""
+}
+
+// connectOptions builds the credential options for a connection. With
+// --verbose, where each setting came from is logged to stderr, labelled
+// with the database.
+func connectOptions(source, label string) db.ConnectOptions {
+	opts := db.ConnectOptions{
+		PasswordFile:    passwordFile,
+		PasswordCommand: passwordCommand,
+		TLSCert:         tlsCert,
+		TLSKey:          tlsKey,
+		TLSRootCA:       tlsRootCA,
+		Source:          source,
+	}
+	if isVerbose() {
+		opts.Log = func(line string) {
+			fmt.Fprintf(os.Stderr, "Connection (%s): %s\n", label, line)
+		}
+	}
+	return opts
 }
 
 // isVerbose returns true if verbose mode is enabled
 func isVerbose() bool {
 	return verbose
 }
+
+// jsonOutput returns true if --output json was requested
+func jsonOutput() bool {
+	return outputFormat == "json"
+}
+
+// checkOutputFormat validates --output for the running command. Commands
+// may accept extra formats by listing them, comma separated, in their
+// "output_formats" annotation.
+func checkOutputFormat(cmd *cobra.Command) error {
+	switch outputFormat {
+	case "text", "json":
+		return nil
+	}
+	for _, f := range strings.Split(cmd.Annotations["output_formats"], ",") {
+		if f == outputFormat {
+			return nil
+		}
+	}
+	return withCode(codeUsage, fmt.Errorf("unsupported output format %q for %s", outputFormat, cmd.Name()))
+}
+
+// configOptional returns true for commands that work without the project
+// config, marked with the "config: optional" annotation on them or a parent
+// command. A broken pgmigrate.yaml or an unknown --env does not stop them;
+// env list reports config errors itself.
+func configOptional(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		switch c.Name() {
+		case "help", "completion", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+			return true
+		}
+		if c.Annotations["config"] == "optional" {
+			return true
+		}
+	}
+	return false
+}
+
+// useProjectConfig applies the project config to the command: its default
+// output format, and the environment selected with --env
+func useProjectConfig(cmd *cobra.Command) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if cfg.Output != "" && !cmd.Flags().Changed("output") {
+		outputFormat = cfg.Output
+	}
+
+	if envName == "" {
+		return nil
+	}
+	if databaseURL != "" {
+		return withCode(codeUsage, fmt.Errorf("--env cannot be combined with --database-url"))
+	}
+	_, url, err := cfg.UseEnvironment(envName)
+	if err != nil {
+		return withCode(codeUsage, err)
+	}
+	envURL = url
+	return nil
+}
+
+// loadConfig returns the project config from --config, or from the
+// nearest pgmigrate.yaml in the current directory or its parents
+func loadConfig() (*config.Config, error) {
+	if projectConfig != nil {
+		return projectConfig, nil
+	}
+
+	path, required := configFile, true
+	if path == "" {
+		found, err := config.Find(".")
+		if err != nil {
+			return nil, withCode(codeFile, err)
+		}
+		path, required = found, false
+		if path == "" {
+			path = config.FileName
+		}
+	}
+
+	cfg, err := config.Load(path, required)
+	if err != nil {
+		return nil, withCode(codeFile, err)
+	}
+	projectConfig = cfg
+	return cfg, nil
+}
+
+// dial opens a connection to url, which came from source. With --wait it
+// retries while the server is starting, reporting each retry on stderr with
+// label, and with --wait-extension until the pg_migrate extension is
+// installed.
+func dial(ctx context.Context, url, source, label string) (*pgx.Conn, error) {
+	connOpts := connectOptions(source, label)
+	if waitTimeout <= 0 {
+		conn, err := db.Connect(ctx, url, connOpts)
+		if err != nil {
+			return nil, withCode(codeConnection, err)
+		}
+		return conn, nil
+	}
+
+	conn, err := db.ConnectWait(ctx, url, connOpts, db.WaitOptions{
+		Timeout:   waitTimeout,
+		Extension: waitExt,
+		OnRetry: func(attempt int, wait time.Duration, err error) {
+			fmt.Fprintf(os.Stderr, "Waiting for %s (attempt %d, retrying in %s): %v\n",
+				label, attempt, wait.Round(time.Millisecond), err)
+		},
+	})
+	if errors.Is(err, db.ErrExtensionNotInstalled) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, withCode(codeConnection, err)
+	}
+	return conn, nil
+}
+
+// defaultSchemaFile returns the schema file plan and apply read when none
+// is given: the project config's schema, else schema.yaml
+func defaultSchemaFile() string {
+	if cfg, err := loadConfig(); err == nil && cfg.Schema != "" {
+		return cfg.Path(cfg.Schema)
+	}
+	return "schema.yaml"
+}
+
+// connect opens a database connection and checks that the pg_migrate
+// extension is installed
+func connect(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := dial(ctx, getDatabaseURL(), databaseURLSource(), "database")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.CheckExtension(ctx, conn); err != nil {
+		db.Close(conn)
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/output"
+	"github.com/matroidbe/pgmigrate/internal/schema"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tenantPattern string
+	tenantQuery   string
+)
+
+// addTenantFlags registers --tenants and --tenants-query on a command
+func addTenantFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&tenantPattern, "tenants", "",
+		"Stamp the tenant_template schema onto every schema matching this pattern, e.g. 'tenant_*'")
+	cmd.Flags().StringVar(&tenantQuery, "tenants-query", "",
+		"Like --tenants, with the schemas listed by this SQL query")
+}
+
+// tenantMode returns true if --tenants or --tenants-query was given
+func tenantMode() bool {
+	return tenantPattern != "" || tenantQuery != ""
+}
+
+// changeCount is one row of the tenant change matrix: a change written
+// against the template schema and how many tenants need it
+type changeCount struct {
+	Change  string `json:"change"`
+	Safety  string `json:"safety"`
+	Tenants int    `json:"tenants"`
+}
+
+// tenantSchemas returns the tenant schemas selected by --tenants or
+// --tenants-query, without the template itself
+func tenantSchemas(ctx context.Context, conn *pgx.Conn, template string) ([]string, error) {
+	if tenantPattern != "" && tenantQuery != "" {
+		return nil, withCode(codeUsage, fmt.Errorf("--tenants and --tenants-query cannot be combined"))
+	}
+
+	var names []string
+	var err error
+	if tenantQuery != "" {
+		if names, err = db.QuerySchemas(ctx, conn, tenantQuery); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := path.Match(tenantPattern, ""); err != nil {
+			return nil, withCode(codeUsage, fmt.Errorf("--tenants: invalid pattern %q", tenantPattern))
+		}
+		all, err := db.ListSchemas(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range all {
+			if ok, _ := path.Match(tenantPattern, name); ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var tenants []string
+	for _, name := range names {
+		if name != template {
+			tenants = append(tenants, name)
+		}
+	}
+	if len(tenants) == 0 {
+		return nil, fmt.Errorf("no tenant schemas found")
+	}
+	return tenants, nil
+}
+
+// planTenants stamps the template schema onto each tenant schema and plans
+// it. Tenants share the connection, so they are planned one at a time.
+func planTenants(ctx context.Context, conn *pgx.Conn, command, schemaFile string, yamlContent []byte) (*fleetReport, error) {
+	opts, _, err := schema.ExtractOptions(yamlContent)
+	if err != nil {
+		return nil, withCode(codeFile, err)
+	}
+	if opts.TenantTemplate == "" {
+		return nil, withCode(codeUsage, fmt.Errorf("%s has no %s key naming the template schema",
+			schemaFile, schema.TenantTemplateKey))
+	}
+
+	tenants, err := tenantSchemas(ctx, conn, opts.TenantTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	dbName, err := db.CurrentDatabase(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	report := &fleetReport{Template: opts.TenantTemplate, Summary: map[string]int{},
+		noun: "tenant", confirm: dbName}
+	for _, tenant := range tenants {
+		report.Targets = append(report.Targets, &targetReport{Name: tenant, conn: conn, shared: true})
+	}
+
+	forEachTarget(report.Targets, 1, func(r *targetReport) error {
+		if err := runHooks(ctx, conn, hookPayload{Hook: hookBeforePlan, Command: command,
+			SchemaFile: schemaFile, Target: r.Name}); err != nil {
+			return err
+		}
+
+		stamped, err := schema.StampTenant(yamlContent, opts.TenantTemplate, r.Name)
+		if err != nil {
+			return withCode(codeFile, err)
+		}
+
+		// Data migrations are not tenant-aware, so none are planned
+		plan, schemaOpts, err := planSchema(ctx, conn, "", stamped)
+		if err != nil {
+			return err
+		}
+		if plan, err = filterPlan(plan); err != nil {
+			return err
+		}
+		r.Plan, r.schemaOpts, r.yaml = plan, schemaOpts, stamped
+
+		r.Status = targetPending
+		if plan.IsEmpty() {
+			r.Status = targetUnchanged
+		}
+		return nil
+	})
+
+	report.Changes = tenantChangeMatrix(report)
+	return report, nil
+}
+
+// tenantChangeMatrix counts, for every change written against the template
+// schema, how many tenants need it
+func tenantChangeMatrix(report *fleetReport) []changeCount {
+	var matrix []changeCount
+	index := map[string]int{}
+
+	for _, r := range report.Targets {
+		if r.Plan == nil {
+			continue
+		}
+		groups := []struct {
+			safety  string
+			changes []db.Change
+		}{
+			{"safe", r.Plan.Safe},
+			{"destructive", r.Plan.Destructive},
+			{"breaking", r.Plan.Breaking},
+		}
+		for _, g := range groups {
+			for _, c := range g.changes {
+				key := templateKey(c.Key(), r.Name, report.Template)
+				i, ok := index[key]
+				if !ok {
+					i = len(matrix)
+					index[key] = i
+					matrix = append(matrix, changeCount{Change: key, Safety: g.safety})
+				}
+				matrix[i].Tenants++
+			}
+		}
+	}
+	return matrix
+}
+
+// templateKey writes a change key of a tenant against the template schema
+func templateKey(key, tenant, template string) string {
+	kind, object, _ := strings.Cut(key, " ")
+	if object == tenant || strings.HasPrefix(object, tenant+".") {
+		object = template + strings.TrimPrefix(object, tenant)
+	}
+	return kind + " " + object
+}
+
+// runTenantPlan plans the template schema against every tenant schema
+func runTenantPlan(cmd *cobra.Command, schemaFile string, yamlContent []byte) error {
+	ctx := cmd.Context()
+
+	if targetsFile != "" {
+		return withCode(codeUsage, fmt.Errorf("--tenants cannot be combined with --targets"))
+	}
+
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close(conn)
+
+	report, err := planTenants(ctx, conn, "plan", schemaFile, yamlContent)
+	if err != nil {
+		return err
+	}
+	return finishFleetReport(report)
+}
+
+// runTenantApply stamps the template schema onto every tenant schema. Each
+// tenant is applied in its own transaction, under one apply lock.
+func runTenantApply(cmd *cobra.Command, schemaFile string, yamlContent []byte) error {
+	ctx := cmd.Context()
+
+	if targetsFile != "" {
+		return withCode(codeUsage, fmt.Errorf("--tenants cannot be combined with --targets"))
+	}
+	if applyInteractive {
+		return withCode(codeUsage, fmt.Errorf("--interactive cannot be combined with --tenants"))
+	}
+	if applyEvents != "" {
+		return withCode(codeUsage, fmt.Errorf("--events cannot be combined with --tenants"))
+	}
+
+	archive, err := archiveEnabled(cmd)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close(conn)
+
+	if err := db.AcquireApplyLock(ctx, conn, lockWait); err != nil {
+		return err
+	}
+	defer db.ReleaseApplyLock(ctx, conn)
+
+	report, err := planTenants(ctx, conn, "apply", schemaFile, yamlContent)
+	if err != nil {
+		return err
+	}
+	for _, r := range report.Targets {
+		if r.Status != targetPending {
+			continue
+		}
+		if err := checkTarget(ctx, r); err != nil {
+			r.fail(err)
+		}
+	}
+
+	return applyFleet(ctx, report, schemaFile, archive, 1, "pgmigrate apply --tenants")
+}
+
+// printChangeMatrix prints how many tenants need each change
+func printChangeMatrix(report *fleetReport) {
+	if len(report.Changes) == 0 {
+		return
+	}
+
+	fmt.Printf("Changes to template schema %s:\n\n", output.Bold(report.Template))
+	for _, c := range report.Changes {
+		symbol := output.Green("+")
+		switch c.Safety {
+		case "destructive":
+			symbol = output.Red("-")
+		case "breaking":
+			symbol = output.Yellow("!")
+		}
+		fmt.Printf("  %s %-50s %d/%d tenant(s)\n", symbol, c.Change, c.Tenants, len(report.Targets))
+	}
+	fmt.Println()
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/output"
 	"github.com/spf13/cobra"
 )
 
@@ -12,10 +13,44 @@ var versionCmd = &cobra.Command{
 	Short: "Show CLI and extension versions",
 	Long: `Displays the pgmigrate CLI version and, if connected to a database,
 the pg_migrate extension version.`,
-	RunE: runVersion,
+	Annotations: map[string]string{"config": "optional"},
+	RunE:        runVersion,
+}
+
+// versionReport is the JSON data of the version command. ExtensionStatus
+// is one of installed, not_installed or unreachable.
+type versionReport struct {
+	CLIVersion       string `json:"cli_version"`
+	GitCommit        string `json:"git_commit,omitempty"`
+	ExtensionStatus  string `json:"extension_status"`
+	ExtensionVersion string `json:"extension_version,omitempty"`
 }
 
 func runVersion(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	report := versionReport{CLIVersion: Version, GitCommit: GitCommit}
+
+	// Try to get extension version
+	conn, err := db.Connect(ctx, getDatabaseURL(), connectOptions(databaseURLSource(), "database"))
+	if err != nil {
+		report.ExtensionStatus = "unreachable"
+	} else {
+		defer db.Close(conn)
+
+		extVersion, err := db.GetExtensionVersion(ctx, conn)
+		if err != nil {
+			report.ExtensionStatus = "not_installed"
+		} else {
+			report.ExtensionStatus = "installed"
+			report.ExtensionVersion = extVersion
+		}
+	}
+
+	if jsonOutput() {
+		return output.PrintEnvelope("version", report)
+	}
+
 	// CLI version
 	fmt.Printf("pgmigrate CLI %s", Version)
 	if GitCommit != "" {
@@ -27,19 +62,13 @@ func runVersion(cmd *cobra.Command, args []string) error {
 	}
 	fmt.Println()
 
-	// Try to get extension version
-	conn, err := db.Connect(getDatabaseURL())
-	if err != nil {
+	switch report.ExtensionStatus {
+	case "unreachable":
 		fmt.Println("pg_migrate extension: (unable to connect)")
-		return nil
-	}
-	defer conn.Close(cmd.Context())
-
-	extVersion, err := db.GetExtensionVersion(conn)
-	if err != nil {
+	case "not_installed":
 		fmt.Println("pg_migrate extension: not installed")
-	} else {
-		fmt.Printf("pg_migrate extension: %s\n", extVersion)
+	default:
+		fmt.Printf("pg_migrate extension: %s\n", report.ExtensionVersion)
 	}
 
 	return nil
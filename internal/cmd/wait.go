@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/output"
+	"github.com/spf13/cobra"
+)
+
+// defaultWait is how long 'pgmigrate wait' waits without --wait
+const defaultWait = 60 * time.Second
+
+var waitCmd = &cobra.Command{
+	Use:   "wait",
+	Short: "Wait until the database accepts connections",
+	Long: `Retries connecting to the database with exponential backoff until it
+accepts connections, for up to --wait (default 60s). Each retry is reported
+on stderr. With --wait-extension it also waits until the pg_migrate
+extension is installed.
+
+Use it in docker-compose or CI before running other commands, or pass --wait
+to those commands directly.
+
+Examples:
+  pgmigrate wait
+  pgmigrate wait --wait 2m --wait-extension`,
+	Args: cobra.NoArgs,
+	RunE: runWait,
+}
+
+// waitReport is the JSON data of wait
+type waitReport struct {
+	WaitedMs         int64  `json:"waited_ms"`
+	ExtensionVersion string `json:"extension_version,omitempty"`
+}
+
+func runWait(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	if !cmd.Flags().Changed("wait") {
+		waitTimeout = defaultWait
+	}
+
+	start := time.Now()
+	conn, err := dial(ctx, getDatabaseURL(), databaseURLSource(), "database")
+	if err != nil {
+		return err
+	}
+	defer db.Close(conn)
+
+	report := waitReport{WaitedMs: time.Since(start).Milliseconds()}
+	if version, err := db.GetExtensionVersion(ctx, conn); err == nil {
+		report.ExtensionVersion = version
+	}
+
+	if jsonOutput() {
+		return output.PrintEnvelope("wait", report)
+	}
+
+	waited := time.Duration(report.WaitedMs) * time.Millisecond
+	output.PrintSuccess(fmt.Sprintf("Database is ready (waited %s).", waited))
+	if report.ExtensionVersion != "" {
+		fmt.Printf("pg_migrate extension: %s\n", report.ExtensionVersion)
+	} else {
+		fmt.Println("pg_migrate extension: not installed")
+	}
+	return nil
+}
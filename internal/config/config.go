@@ -0,0 +1,247 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the project configuration file
+const FileName = "pgmigrate.yaml"
+
+// Config is the project configuration read from pgmigrate.yaml
+type Config struct {
+	// Schema is the schema file plan and apply read when none is given
+	Schema string `yaml:"schema"`
+
+	// Output is the default --output format
+	Output string `yaml:"output"`
+
+	// Environments are the databases selected with --env
+	Environments map[string]*Environment `yaml:"environments"`
+
+	Apply  ApplyDefaults `yaml:"apply"`
+	Hooks  Hooks         `yaml:"hooks"`
+	Notify []Notifier    `yaml:"notify"`
+	Policy Policy        `yaml:"policy"`
+
+	// Dir is the directory of the config file. Relative paths in the
+	// config are resolved against it.
+	Dir string `yaml:"-"`
+
+	// File is the path of the config file, or "" if there is none
+	File string `yaml:"-"`
+}
+
+// ApplyDefaults are defaults for apply flags
+type ApplyDefaults struct {
+	// Archive keeps the data of dropped tables and columns, like --archive
+	Archive bool `yaml:"archive"`
+}
+
+// Hooks run around plan and apply
+type Hooks struct {
+	BeforePlan  []Hook `yaml:"before_plan"`
+	BeforeApply []Hook `yaml:"before_apply"`
+	AfterApply  []Hook `yaml:"after_apply"`
+	OnFailure   []Hook `yaml:"on_failure"`
+}
+
+// Policy restricts what apply may do, whatever flags are passed
+type Policy struct {
+	// NeverDrop lists objects apply must never drop, as "schema",
+	// "schema.table" or "schema.table.column" patterns with shell wildcards
+	NeverDrop []string `yaml:"never_drop"`
+
+	// RequireInteractive makes apply pick each change with --interactive
+	RequireInteractive bool `yaml:"require_interactive"`
+
+	// ForbidDestructive and ForbidBreaking refuse --allow-destructive,
+	// --allow-destructive-on and --allow-breaking
+	ForbidDestructive bool `yaml:"forbid_destructive"`
+	ForbidBreaking    bool `yaml:"forbid_breaking"`
+}
+
+// Hook is either a SQL file run on the apply connection or a shell command
+type Hook struct {
+	SQL     string `yaml:"sql"`
+	Command string `yaml:"command"`
+}
+
+// Notification events
+const (
+	EventSuccess            = "success"
+	EventFailure            = "failure"
+	EventSkippedDestructive = "skipped_destructive"
+)
+
+// Notifier tells someone how an apply ended, by webhook or by email
+type Notifier struct {
+	// On lists the events to send: success, failure and
+	// skipped_destructive. Empty means all of them.
+	On []string `yaml:"on"`
+
+	Webhook *Webhook `yaml:"webhook"`
+	Email   *Email   `yaml:"email"`
+}
+
+// Notifies returns true if the notifier wants the event
+func (n Notifier) Notifies(event string) bool {
+	if len(n.On) == 0 {
+		return true
+	}
+	for _, e := range n.On {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook posts the notification as JSON
+type Webhook struct {
+	// URL is the endpoint. URLEnv names an environment variable holding it
+	// instead, so the config needs no secrets.
+	URL     string            `yaml:"url"`
+	URLEnv  string            `yaml:"url_env"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Endpoint returns the webhook URL
+func (w *Webhook) Endpoint() string {
+	if w.URLEnv != "" {
+		return os.Getenv(w.URLEnv)
+	}
+	return w.URL
+}
+
+// Email sends the notification through an SMTP server
+type Email struct {
+	// SMTP is the server as host:port
+	SMTP string   `yaml:"smtp"`
+	From string   `yaml:"from"`
+	To   []string `yaml:"to"`
+
+	// Username and the password in PasswordEnv authenticate with the
+	// server, if set
+	Username    string `yaml:"username"`
+	PasswordEnv string `yaml:"password_env"`
+}
+
+// Find returns the path of the nearest config file in dir or one of its
+// parents, or "" if there is none
+func Find(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		p := filepath.Join(dir, FileName)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// Load reads the config file at path. A missing file yields an empty
+// config unless required is set.
+func Load(path string, required bool) (*Config, error) {
+	cfg := &Config{Dir: filepath.Dir(path)}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", path, err)
+	}
+
+	cfg.File = path
+	if err := yaml.Unmarshal(content, cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Path resolves a path from the config relative to its directory
+func (c *Config) Path(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(c.Dir, p)
+}
+
+func (c *Config) validate() error {
+	if err := c.Policy.validate("policy"); err != nil {
+		return err
+	}
+	if err := c.Hooks.validate("hooks"); err != nil {
+		return err
+	}
+
+	for i, n := range c.Notify {
+		if (n.Webhook == nil) == (n.Email == nil) {
+			return fmt.Errorf("notify[%d]: set exactly one of webhook or email", i)
+		}
+		for _, e := range n.On {
+			if e != EventSuccess && e != EventFailure && e != EventSkippedDestructive {
+				return fmt.Errorf("notify[%d]: unknown event %q (use success, failure or skipped_destructive)", i, e)
+			}
+		}
+		if w := n.Webhook; w != nil && (w.URL == "") == (w.URLEnv == "") {
+			return fmt.Errorf("notify[%d].webhook: set exactly one of url or url_env", i)
+		}
+		if m := n.Email; m != nil && (m.SMTP == "" || m.From == "" || len(m.To) == 0) {
+			return fmt.Errorf("notify[%d].email: smtp, from and to are required", i)
+		}
+	}
+
+	for name, env := range c.Environments {
+		if err := env.validate("environments." + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p Policy) validate(prefix string) error {
+	for _, pattern := range p.NeverDrop {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("%s.never_drop: invalid pattern %q", prefix, pattern)
+		}
+	}
+	return nil
+}
+
+func (h Hooks) validate(prefix string) error {
+	stages := map[string][]Hook{
+		"before_plan":  h.BeforePlan,
+		"before_apply": h.BeforeApply,
+		"after_apply":  h.AfterApply,
+		"on_failure":   h.OnFailure,
+	}
+	for stage, hooks := range stages {
+		for i, hook := range hooks {
+			if (hook.SQL == "") == (hook.Command == "") {
+				return fmt.Errorf("%s.%s[%d]: set exactly one of sql or command", prefix, stage, i)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Environment is a named database such as dev, staging or prod
+type Environment struct {
+	Description string `yaml:"description"`
+
+	// The connection URL comes from exactly one of URL, the environment
+	// variable URLEnv, or the file URLFile, so the config needs no secrets
+	URL     string `yaml:"url"`
+	URLEnv  string `yaml:"url_env"`
+	URLFile string `yaml:"url_file"`
+
+	// Policy and Hooks add to the top-level ones
+	Policy Policy `yaml:"policy"`
+	Hooks  Hooks  `yaml:"hooks"`
+}
+
+func (e *Environment) validate(prefix string) error {
+	if e == nil {
+		return fmt.Errorf("%s: set one of url, url_env or url_file", prefix)
+	}
+	sources := 0
+	for _, s := range []string{e.URL, e.URLEnv, e.URLFile} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("%s: set exactly one of url, url_env or url_file", prefix)
+	}
+	if err := e.Policy.validate(prefix + ".policy"); err != nil {
+		return err
+	}
+	return e.Hooks.validate(prefix + ".hooks")
+}
+
+// Source describes where the environment's URL comes from, with any
+// password left out
+func (e *Environment) Source() string {
+	switch {
+	case e.URLEnv != "":
+		return "$" + e.URLEnv
+	case e.URLFile != "":
+		return "file " + e.URLFile
+	}
+	if u, err := url.Parse(e.URL); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	return "url"
+}
+
+// EnvironmentNames returns the names of the environments, sorted
+func (c *Config) EnvironmentNames() []string {
+	names := make([]string, 0, len(c.Environments))
+	for name := range c.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UseEnvironment selects an environment: its policy and hooks are added to
+// the config's own, and its connection URL is returned
+func (c *Config) UseEnvironment(name string) (*Environment, string, error) {
+	env, ok := c.Environments[name]
+	if !ok {
+		if len(c.Environments) == 0 {
+			return nil, "", fmt.Errorf("unknown environment %q: no environments are configured", name)
+		}
+		return nil, "", fmt.Errorf("unknown environment %q (have %s)", name,
+			strings.Join(c.EnvironmentNames(), ", "))
+	}
+
+	databaseURL, err := c.environmentURL(name, env)
+	if err != nil {
+		return nil, "", err
+	}
+
+	c.Policy.NeverDrop = append(c.Policy.NeverDrop, env.Policy.NeverDrop...)
+	c.Policy.RequireInteractive = c.Policy.RequireInteractive || env.Policy.RequireInteractive
+	c.Policy.ForbidDestructive = c.Policy.ForbidDestructive || env.Policy.ForbidDestructive
+	c.Policy.ForbidBreaking = c.Policy.ForbidBreaking || env.Policy.ForbidBreaking
+
+	c.Hooks.BeforePlan = append(c.Hooks.BeforePlan, env.Hooks.BeforePlan...)
+	c.Hooks.BeforeApply = append(c.Hooks.BeforeApply, env.Hooks.BeforeApply...)
+	c.Hooks.AfterApply = append(c.Hooks.AfterApply, env.Hooks.AfterApply...)
+	c.Hooks.OnFailure = append(c.Hooks.OnFailure, env.Hooks.OnFailure...)
+
+	return env, databaseURL, nil
+}
+
+// environmentURL reads the connection URL of an environment
+func (c *Config) environmentURL(name string, env *Environment) (string, error) {
+	switch {
+	case env.URLEnv != "":
+		v := os.Getenv(env.URLEnv)
+		if v == "" {
+			return "", fmt.Errorf("environment %s: %s is not set", name, env.URLEnv)
+		}
+		return v, nil
+	case env.URLFile != "":
+		content, err := os.ReadFile(c.Path(env.URLFile))
+		if err != nil {
+			return "", fmt.Errorf("environment %s: cannot read url_file: %w", name, err)
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+	return env.URL, nil
+}
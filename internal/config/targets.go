@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Target is one database of a fleet
+type Target struct {
+	Name string `yaml:"name"`
+
+	// URL is the connection URL. URLEnv names an environment variable
+	// holding it instead, so the file needs no secrets.
+	URL    string `yaml:"url"`
+	URLEnv string `yaml:"url_env"`
+}
+
+// targetsFile is the layout of a targets file
+type targetsFile struct {
+	Targets []Target `yaml:"targets"`
+}
+
+// LoadTargets reads a targets file and resolves each target's URL
+func LoadTargets(path string) ([]Target, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", path, err)
+	}
+
+	var f targetsFile
+	if err := yaml.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", path, err)
+	}
+	if len(f.Targets) == 0 {
+		return nil, fmt.Errorf("%s: no targets defined", path)
+	}
+
+	seen := map[string]bool{}
+	for i := range f.Targets {
+		t := &f.Targets[i]
+		if t.Name == "" {
+			return nil, fmt.Errorf("%s: target %d has no name", path, i+1)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("%s: duplicate target %q", path, t.Name)
+		}
+		seen[t.Name] = true
+
+		if (t.URL == "") == (t.URLEnv == "") {
+			return nil, fmt.Errorf("%s: target %q needs either url or url_env", path, t.Name)
+		}
+		if t.URLEnv != "" {
+			t.URL = os.Getenv(t.URLEnv)
+			if t.URL == "" {
+				return nil, fmt.Errorf("%s: target %q: %s is not set", path, t.Name, t.URLEnv)
+			}
+		}
+	}
+
+	return f.Targets, nil
+}
@@ -0,0 +1,529 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Event types reported while applying
+const (
+	EventApplyStarted   = "apply_started"
+	EventChangeStarted  = "change_started"
+	EventChangeFinished = "change_finished"
+	EventChangeFailed   = "change_failed"
+	EventApplyFinished  = "apply_finished"
+	EventApplyFailed    = "apply_failed"
+	EventRetry          = "retry"
+)
+
+// Apply phases: changes run in one transaction first, then changes with
+// their own step sequence run outside it
+const (
+	ApplyPhaseTransaction = "transaction"
+	ApplyPhaseOnline      = "online"
+)
+
+// Event reports progress of an apply
+type Event struct {
+	Type      string    `json:"type"`
+	Phase     string    `json:"phase,omitempty"`
+	Time      time.Time `json:"time"`
+	Index     int       `json:"index,omitempty"` // 1-based position of the change
+	Total     int       `json:"total"`
+	Attempt   int       `json:"attempt,omitempty"`
+	Change    *Change   `json:"change,omitempty"`
+	Script    string    `json:"script,omitempty"` // data migration file name
+	SQL       string    `json:"sql,omitempty"`
+	ElapsedMs int64     `json:"elapsed_ms"`
+	BackoffMs int64     `json:"backoff_ms,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ApplyOptions controls how changes are executed
+type ApplyOptions struct {
+	// Reason is recorded in history for every executed statement
+	Reason string
+
+	// BreakingReason, if set, makes Apply include breaking changes and is
+	// recorded in history for their statements instead of Reason
+	BreakingReason string
+
+	// DestructiveFilter, if set, limits the destructive changes applied with
+	// allowDestructive to those it accepts. The rest are skipped.
+	DestructiveFilter func(Change) bool
+
+	// Archive keeps dropped tables and columns in the archive schema
+	// instead of dropping their data
+	Archive bool
+
+	// LockTimeout and StatementTimeout are set on the session when non-zero
+	LockTimeout      time.Duration
+	StatementTimeout time.Duration
+
+	// Retries is the number of extra attempts after a lock timeout or
+	// deadlock. The wait before attempt n+1 is RetryBackoff * 2^(n-1).
+	Retries      int
+	RetryBackoff time.Duration
+
+	// OnEvent, if set, receives progress events
+	OnEvent func(Event)
+}
+
+func (o *ApplyOptions) emit(e Event) {
+	if o.OnEvent == nil {
+		return
+	}
+	e.Time = time.Now()
+	o.OnEvent(e)
+}
+
+// applyConn is the connection changes are applied on
+type applyConn interface {
+	querier
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// step is a change or data migration queued for execution, with the
+// reason recorded for it. Online steps run after the transaction.
+type step struct {
+	change Change
+	script *DataMigration
+	reason string
+	online bool
+
+	// sql, if set, replaces the change's DDL, and archive is recorded
+	// when it has run
+	sql     []string
+	archive *Archive
+}
+
+// statements returns the SQL a change step executes
+func (st step) statements() ([]string, error) {
+	if st.sql != nil {
+		return st.sql, nil
+	}
+	return ChangeSQL(st.change)
+}
+
+func changeStep(c Change, reason string) step {
+	return step{change: c, reason: reason, online: len(c.Steps) > 0}
+}
+
+// Apply executes the safe changes of a plan, and its destructive changes
+// if allowDestructive is set. Breaking changes are only applied when
+// opts.BreakingReason is set. If it fails or ctx is cancelled once changes
+// are running, the result lists the changes committed before that.
+func Apply(ctx context.Context, conn *pgx.Conn, plan *PlanResult, allowDestructive bool, opts ApplyOptions) (*ApplyResult, error) {
+	return apply(ctx, conn, plan, allowDestructive, opts)
+}
+
+func apply(ctx context.Context, conn applyConn, plan *PlanResult, allowDestructive bool, opts ApplyOptions) (*ApplyResult, error) {
+	steps, skipped := planSteps(plan, allowDestructive, opts)
+	if opts.Archive {
+		if err := prepareArchives(ctx, conn, steps); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := applySteps(ctx, conn, steps, opts)
+	if result != nil {
+		result.Skipped = skipped
+	}
+	return result, err
+}
+
+// planSteps returns the steps Apply executes for a plan, and the
+// destructive changes it skips
+func planSteps(plan *PlanResult, allowDestructive bool, opts ApplyOptions) ([]step, []Change) {
+	var steps []step
+	for _, c := range plan.Safe {
+		steps = append(steps, changeStep(c, opts.Reason))
+	}
+
+	var skipped []Change
+	for _, c := range plan.Destructive {
+		if allowDestructive && (opts.DestructiveFilter == nil || opts.DestructiveFilter(c)) {
+			steps = append(steps, changeStep(c, opts.Reason))
+		} else {
+			skipped = append(skipped, c)
+		}
+	}
+
+	if opts.BreakingReason != "" {
+		for _, c := range plan.Breaking {
+			steps = append(steps, changeStep(c, opts.BreakingReason))
+		}
+	}
+
+	return withDataMigrations(steps, plan, opts.Reason), skipped
+}
+
+// withDataMigrations places each pending data migration right after the
+// change its After names, or at the end of the transaction if it names none
+// or a change that is not in the plan. Migrations whose change is planned
+// but not applied stay pending.
+func withDataMigrations(steps []step, plan *PlanResult, reason string) []step {
+	if len(plan.DataMigrations) == 0 {
+		return steps
+	}
+
+	planned := map[string]bool{}
+	for _, c := range plan.ExecutionOrder() {
+		planned[c.Key()] = true
+	}
+
+	after := map[string][]*DataMigration{}
+	var atEnd []step
+	for i := range plan.DataMigrations {
+		m := &plan.DataMigrations[i]
+		if m.After != "" && planned[m.After] {
+			after[m.After] = append(after[m.After], m)
+		} else {
+			atEnd = append(atEnd, step{script: m, reason: reason})
+		}
+	}
+
+	var result []step
+	for _, st := range steps {
+		result = append(result, st)
+		for _, m := range after[st.change.Key()] {
+			result = append(result, step{script: m, reason: reason, online: st.online})
+		}
+	}
+	return append(result, atEnd...)
+}
+
+func applySteps(ctx context.Context, conn applyConn, steps []step, opts ApplyOptions) (*ApplyResult, error) {
+	start := time.Now()
+	total := len(steps)
+
+	if err := setSessionTimeouts(ctx, conn, opts); err != nil {
+		return nil, err
+	}
+
+	for _, st := range steps {
+		if st.script != nil {
+			if err := ensureDataMigrationsTable(ctx, conn); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	// Changes with their own step sequence run after the transaction
+	var inTx, online []step
+	for _, st := range steps {
+		if st.online {
+			online = append(online, st)
+		} else {
+			inTx = append(inTx, st)
+		}
+	}
+
+	result := &ApplyResult{Attempts: 1}
+	if len(inTx) > 0 {
+		attempts, err := applyTransaction(ctx, conn, inTx, total, opts, start)
+		result.Attempts = attempts
+		if err != nil {
+			result.DurationMs = int(time.Since(start).Milliseconds())
+			return result, err
+		}
+		for _, st := range inTx {
+			result.record(st)
+		}
+	} else {
+		opts.emit(Event{Type: EventApplyStarted, Total: total, Attempt: 1})
+	}
+
+	for i, st := range online {
+		if err := applyOnline(ctx, conn, st, len(inTx)+i+1, total, opts); err != nil {
+			opts.emit(Event{Type: EventApplyFailed, Total: total, Phase: ApplyPhaseOnline,
+				ElapsedMs: time.Since(start).Milliseconds(), Error: err.Error()})
+			if committed := len(result.Applied) + len(result.DataMigrations); committed > 0 {
+				err = fmt.Errorf("%w (%d earlier step(s) were already committed)", err, committed)
+			}
+			result.DurationMs = int(time.Since(start).Milliseconds())
+			return result, err
+		}
+		result.record(st)
+	}
+
+	result.DurationMs = int(time.Since(start).Milliseconds())
+	opts.emit(Event{Type: EventApplyFinished, Total: total, Attempt: result.Attempts,
+		ElapsedMs: int64(result.DurationMs)})
+
+	return result, nil
+}
+
+// record adds an executed step to the result
+func (r *ApplyResult) record(st step) {
+	if st.script != nil {
+		r.DataMigrations = append(r.DataMigrations, st.script.Name)
+		return
+	}
+	r.Applied = append(r.Applied, st.change)
+}
+
+// event returns the progress event for a step, without its type
+func (st step) event(phase string, index, total int) (Event, error) {
+	ev := Event{Phase: phase, Index: index, Total: total}
+	if st.script != nil {
+		ev.Script = st.script.Name
+		ev.SQL = st.script.SQL
+		return ev, nil
+	}
+
+	stmts, err := st.statements()
+	if err != nil {
+		return ev, err
+	}
+	change := st.change
+	ev.Change = &change
+	ev.SQL = strings.Join(stmts, ";\n")
+	return ev, nil
+}
+
+// applyTransaction runs steps in one transaction, retrying it as a whole.
+// It returns the number of attempts made.
+func applyTransaction(ctx context.Context, conn applyConn, steps []step, total int, opts ApplyOptions, start time.Time) (int, error) {
+	for attempt := 1; ; attempt++ {
+		opts.emit(Event{Type: EventApplyStarted, Total: total, Attempt: attempt})
+
+		err := applyAttempt(ctx, conn, steps, total, opts, attempt)
+		if err == nil {
+			return attempt, nil
+		}
+
+		ev := Event{Total: total, ElapsedMs: time.Since(start).Milliseconds()}
+		if err = retryWait(ctx, &opts, err, attempt, ev); err != nil {
+			ev.Type = EventApplyFailed
+			ev.Attempt = attempt
+			ev.Error = err.Error()
+			opts.emit(ev)
+			return attempt, err
+		}
+	}
+}
+
+// retryWait decides whether a failed attempt is retried. If so, it reports
+// the retry, sleeps for the backoff and returns nil. Otherwise it returns
+// the error to fail with.
+func retryWait(ctx context.Context, opts *ApplyOptions, err error, attempt int, ev Event) error {
+	if attempt > opts.Retries || !IsRetryable(err) {
+		return err
+	}
+
+	backoff := opts.RetryBackoff << (attempt - 1)
+	ev.Type = EventRetry
+	ev.Attempt = attempt
+	ev.BackoffMs = backoff.Milliseconds()
+	ev.Error = err.Error()
+	opts.emit(ev)
+	if sleepErr := sleep(ctx, backoff); sleepErr != nil {
+		return fmt.Errorf("%w while waiting to retry after: %v", sleepErr, err)
+	}
+	return nil
+}
+
+// sleep waits for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// applyOnline runs the step sequence of a change, committing each
+// statement on its own so no lock is held across them. A statement that
+// hits a lock timeout is retried by itself. If the sequence fails, what it
+// left behind is cleaned up.
+func applyOnline(ctx context.Context, conn applyConn, st step, index, total int, opts ApplyOptions) error {
+	ev, err := st.event(ApplyPhaseOnline, index, total)
+	if err != nil {
+		return err
+	}
+	ev.Type = EventChangeStarted
+	opts.emit(ev)
+
+	if st.script != nil {
+		return applyOnlineScript(ctx, conn, st, ev, opts)
+	}
+
+	change := st.change
+
+	exec := func(stmt string) error {
+		_, err := conn.Exec(ctx, "SELECT pgmigrate.dba_migrate($1, $2)", stmt, st.reason)
+		return err
+	}
+	if change.Concurrently {
+		// A build interrupted by a failure leaves an INVALID index behind,
+		// which has to go before the next attempt
+		exec = func(stmt string) error {
+			if err := dropInvalidIndex(ctx, conn, change); err != nil {
+				return err
+			}
+			_, err := conn.Exec(ctx, stmt)
+			return err
+		}
+	}
+
+	changeStart := time.Now()
+	for _, stmt := range change.Steps {
+		for attempt := 1; ; attempt++ {
+			err := exec(stmt)
+			if err == nil {
+				break
+			}
+			retry := ev
+			retry.ElapsedMs = time.Since(changeStart).Milliseconds()
+			if err = retryWait(ctx, &opts, err, attempt, retry); err == nil {
+				continue
+			}
+
+			ev.Type = EventChangeFailed
+			ev.ElapsedMs = time.Since(changeStart).Milliseconds()
+			ev.Error = err.Error()
+			opts.emit(ev)
+
+			// An interrupted apply has lost its connection, so nothing can
+			// be cleaned up. The next apply drops an INVALID index itself.
+			if ctx.Err() != nil {
+				return fmt.Errorf("apply interrupted at %s: %w", change.Key(), err)
+			}
+			for _, cleanup := range onlineCleanup(change) {
+				if _, cleanupErr := conn.Exec(ctx, "SELECT pgmigrate.dba_migrate($1, $2)", cleanup, st.reason); cleanupErr != nil {
+					err = fmt.Errorf("%w; cleanup failed: %v", err, cleanupErr)
+				}
+			}
+			if change.Concurrently {
+				if cleanupErr := dropInvalidIndex(ctx, conn, change); cleanupErr != nil {
+					err = fmt.Errorf("%w; %v", err, cleanupErr)
+				}
+			}
+			return fmt.Errorf("apply failed at %s: %w", change.Key(), err)
+		}
+	}
+
+	ev.Type = EventChangeFinished
+	ev.ElapsedMs = time.Since(changeStart).Milliseconds()
+	opts.emit(ev)
+	return nil
+}
+
+// applyOnlineScript runs a data migration that follows an online change in
+// a transaction of its own
+func applyOnlineScript(ctx context.Context, conn applyConn, st step, ev Event, opts ApplyOptions) error {
+	start := time.Now()
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		return runStep(ctx, tx, st)
+	})
+	ev.ElapsedMs = time.Since(start).Milliseconds()
+	if err != nil {
+		ev.Type = EventChangeFailed
+		ev.Error = err.Error()
+		opts.emit(ev)
+		return err
+	}
+
+	ev.Type = EventChangeFinished
+	opts.emit(ev)
+	return nil
+}
+
+// applyAttempt runs all changes in one transaction
+func applyAttempt(ctx context.Context, conn applyConn, steps []step, total int, opts ApplyOptions, attempt int) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("apply failed: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for i, st := range steps {
+		ev, err := st.event(ApplyPhaseTransaction, i+1, total)
+		if err != nil {
+			return err
+		}
+		ev.Attempt = attempt
+		ev.Type = EventChangeStarted
+		opts.emit(ev)
+
+		stepStart := time.Now()
+		err = runStep(ctx, tx, st)
+		ev.ElapsedMs = time.Since(stepStart).Milliseconds()
+		if err != nil {
+			ev.Type = EventChangeFailed
+			ev.Error = err.Error()
+			opts.emit(ev)
+			return err
+		}
+
+		ev.Type = EventChangeFinished
+		opts.emit(ev)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("apply failed: %w", err)
+	}
+	return nil
+}
+
+// runStep executes a change or data migration in a transaction
+func runStep(ctx context.Context, tx pgx.Tx, st step) error {
+	if st.script != nil {
+		if err := runDataMigration(ctx, tx, st.script, st.reason); err != nil {
+			return fmt.Errorf("data migration %s failed: %w", st.script.Name, err)
+		}
+		return nil
+	}
+
+	stmts, err := st.statements()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(ctx, "SELECT pgmigrate.dba_migrate($1, $2)", stmt, st.reason); err != nil {
+			return fmt.Errorf("apply failed at %s: %w", st.change.Key(), err)
+		}
+	}
+	if st.archive != nil {
+		return recordArchive(ctx, tx, st.archive)
+	}
+	return nil
+}
+
+// setSessionTimeouts applies lock_timeout and statement_timeout to the session
+func setSessionTimeouts(ctx context.Context, conn applyConn, opts ApplyOptions) error {
+	for name, d := range map[string]time.Duration{
+		"lock_timeout":      opts.LockTimeout,
+		"statement_timeout": opts.StatementTimeout,
+	} {
+		if d <= 0 {
+			continue
+		}
+		value := fmt.Sprintf("%dms", d.Milliseconds())
+		if _, err := conn.Exec(ctx, "SELECT set_config($1, $2, false)", name, value); err != nil {
+			return fmt.Errorf("cannot set %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// IsRetryable returns true for errors that a later attempt may not hit:
+// lock_not_available (55P03) and deadlock_detected (40P01)
+func IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "55P03" || pgErr.Code == "40P01"
+}
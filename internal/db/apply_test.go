@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeConn records the SQL an apply executes and fails the statements in
+// fail. Calls it does not implement panic through the nil embedded
+// interface.
+type fakeConn struct {
+	querier
+	executed []string
+	fail     map[string]error
+}
+
+func (c *fakeConn) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if sql == "SELECT pgmigrate.dba_migrate($1, $2)" {
+		sql = args[0].(string)
+	}
+	c.executed = append(c.executed, sql)
+	return pgconn.CommandTag{}, c.fail[sql]
+}
+
+func (c *fakeConn) Begin(context.Context) (pgx.Tx, error) {
+	return &fakeTx{conn: c}, nil
+}
+
+type fakeTx struct {
+	pgx.Tx
+	conn *fakeConn
+}
+
+func (t *fakeTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return t.conn.Exec(ctx, sql, args...)
+}
+
+func (t *fakeTx) Commit(context.Context) error   { return nil }
+func (t *fakeTx) Rollback(context.Context) error { return nil }
+
+func column(t *testing.T, def ColumnDefinition) json.RawMessage {
+	t.Helper()
+	raw, err := json.Marshal(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestApplyEmitsEventPerChange(t *testing.T) {
+	plan := &PlanResult{
+		Safe: []Change{
+			{Type: "create_schema", Name: "app", Safety: "safe"},
+			{Type: "add_column", Schema: "public", Table: "users", Safety: "safe",
+				Column: column(t, ColumnDefinition{Name: "slug", DataType: "text"})},
+			{Type: "create_index", Schema: "public", Table: "users", Safety: "safe",
+				Index: json.RawMessage(`{"name":"users_slug_idx","columns":["slug"]}`)},
+		},
+		Destructive: []Change{
+			{Type: "drop_column", Schema: "public", Table: "users", Safety: "destructive",
+				Column: json.RawMessage(`"legacy"`)},
+		},
+	}
+
+	var events []Event
+	conn := &fakeConn{}
+	result, err := apply(context.Background(), conn, plan, true, ApplyOptions{
+		Reason:  "test",
+		OnEvent: func(ev Event) { events = append(events, ev) },
+	})
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if len(result.Applied) != 4 {
+		t.Fatalf("applied %d changes, want 4", len(result.Applied))
+	}
+	if len(conn.executed) != 4 {
+		t.Fatalf("executed %d statements, want 4: %q", len(conn.executed), conn.executed)
+	}
+
+	want := []string{EventApplyStarted}
+	for range plan.ExecutionOrder() {
+		want = append(want, EventChangeStarted, EventChangeFinished)
+	}
+	want = append(want, EventApplyFinished)
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+
+	index := 0
+	for i, ev := range events {
+		if ev.Type != want[i] {
+			t.Fatalf("event %d is %s, want %s", i, ev.Type, want[i])
+		}
+		if ev.Type != EventChangeStarted {
+			continue
+		}
+		index++
+		if ev.Index != index || ev.Total != 4 {
+			t.Errorf("event %d: position %d/%d, want %d/4", i, ev.Index, ev.Total, index)
+		}
+		if ev.Change == nil || ev.SQL != conn.executed[index-1] {
+			t.Errorf("event %d: SQL %q, want %q", i, ev.SQL, conn.executed[index-1])
+		}
+	}
+}
+
+func TestApplyOnlineReportsCleanupFailure(t *testing.T) {
+	change := Change{Type: "alter_column_nullable", Schema: "public", Table: "users", Safety: "safe",
+		Column: json.RawMessage(`"email"`)}
+	change.Steps = []string{"ADD CHECK", "VALIDATE CHECK"}
+	cleanup := onlineCleanup(change)[0]
+
+	conn := &fakeConn{fail: map[string]error{
+		"VALIDATE CHECK": errors.New("column contains null values"),
+		cleanup:          errors.New("lock timeout"),
+	}}
+	_, err := apply(context.Background(), conn, &PlanResult{Safe: []Change{change}}, false, ApplyOptions{Reason: "test"})
+	if err == nil {
+		t.Fatal("apply succeeded with a failing step")
+	}
+	for _, want := range []string{"column contains null values", "cleanup failed: lock timeout"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ArchiveSchema holds dropped tables and columns archived by apply
+const ArchiveSchema = "pgmigrate_archive"
+
+// archivesTable records what each archive table holds
+const archivesTable = "archives"
+
+// Archive kinds
+const (
+	ArchiveTable  = "table"
+	ArchiveColumn = "column"
+)
+
+// Archive is a dropped table or column kept in the archive schema
+type Archive struct {
+	ID           int       `json:"id"`
+	Kind         string    `json:"kind"`
+	Schema       string    `json:"schema"`
+	Table        string    `json:"table"`
+	Column       string    `json:"column,omitempty"`
+	KeyColumns   []string  `json:"key_columns,omitempty"` // primary key copied with a column
+	ArchiveTable string    `json:"archive_table"`
+	ArchivedAt   time.Time `json:"archived_at"`
+	ArchivedBy   string    `json:"archived_by"`
+}
+
+// Object returns the archived object as "schema.table" or
+// "schema.table.column"
+func (a *Archive) Object() string {
+	if a.Column != "" {
+		return a.Schema + "." + a.Table + "." + a.Column
+	}
+	return a.Schema + "." + a.Table
+}
+
+// prepareArchives replaces the SQL of drop_table and drop_column steps with
+// statements that keep the data in the archive schema. Dropped tables are
+// moved there, renamed with a timestamp suffix. Dropped columns are first
+// copied into an archive table together with the primary key.
+func prepareArchives(ctx context.Context, conn querier, steps []step) error {
+	suffix := time.Now().UTC().Format("20060102150405")
+	prepared := false
+
+	for i := range steps {
+		st := &steps[i]
+		if st.script != nil {
+			continue
+		}
+		c := st.change
+
+		switch c.Type {
+		case "drop_table":
+			a := &Archive{Kind: ArchiveTable, Schema: c.Schema, Table: c.Table,
+				ArchiveTable: archiveName(suffix, c.Schema, c.Table)}
+			st.sql = []string{
+				fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteTable(c.Schema, c.Table), quoteIdent(a.ArchiveTable)),
+				fmt.Sprintf("ALTER TABLE %s SET SCHEMA %s", quoteTable(c.Schema, a.ArchiveTable), quoteIdent(ArchiveSchema)),
+			}
+			st.archive = a
+
+		case "drop_column":
+			column := c.GetColumnName()
+			keys, err := primaryKeyColumns(ctx, conn, c.Schema, c.Table)
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				return fmt.Errorf("cannot archive %s.%s.%s: the table has no primary key", c.Schema, c.Table, column)
+			}
+
+			a := &Archive{Kind: ArchiveColumn, Schema: c.Schema, Table: c.Table, Column: column,
+				KeyColumns: keys, ArchiveTable: archiveName(suffix, c.Schema, c.Table, column)}
+			cols := make([]string, 0, len(keys)+1)
+			for _, k := range append(keys, column) {
+				cols = append(cols, quoteIdent(k))
+			}
+			st.sql = []string{
+				fmt.Sprintf("CREATE TABLE %s AS SELECT %s FROM %s",
+					quoteTable(ArchiveSchema, a.ArchiveTable), strings.Join(cols, ", "), quoteTable(c.Schema, c.Table)),
+				fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", quoteTable(c.Schema, c.Table), quoteIdent(column)),
+			}
+			st.archive = a
+
+		default:
+			continue
+		}
+
+		if !prepared {
+			if err := ensureArchiveSchema(ctx, conn); err != nil {
+				return err
+			}
+			prepared = true
+		}
+	}
+	return nil
+}
+
+// archiveName builds "<parts>__<suffix>", shortened to fit an identifier
+func archiveName(suffix string, parts ...string) string {
+	const maxIdent = 63
+	name := strings.Join(parts, "__")
+	if max := maxIdent - len(suffix) - 2; len(name) > max {
+		name = name[:max]
+	}
+	return name + "__" + suffix
+}
+
+// recordArchive stores the archive record in the caller's transaction
+func recordArchive(ctx context.Context, tx pgx.Tx, a *Archive) error {
+	_, err := tx.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s (kind, schema_name, table_name, column_name, key_columns, archive_table)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+	`, quoteTable(StateSchema, archivesTable)),
+		a.Kind, a.Schema, a.Table, a.Column, a.KeyColumns, a.ArchiveTable)
+	if err != nil {
+		return fmt.Errorf("cannot record archive of %s: %w", a.Object(), err)
+	}
+	return nil
+}
+
+// ListArchives returns all archives, newest first
+func ListArchives(ctx context.Context, conn *pgx.Conn) ([]Archive, error) {
+	exists, err := stateTableExists(ctx, conn, archivesTable)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := conn.Query(ctx, fmt.Sprintf(`
+		SELECT id, kind, schema_name, table_name, coalesce(column_name, ''),
+		       coalesce(key_columns, '{}'), archive_table, archived_at, archived_by
+		FROM %s
+		ORDER BY id DESC
+	`, quoteTable(StateSchema, archivesTable)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot list archives: %w", err)
+	}
+	defer rows.Close()
+
+	var archives []Archive
+	for rows.Next() {
+		var a Archive
+		if err := rows.Scan(&a.ID, &a.Kind, &a.Schema, &a.Table, &a.Column,
+			&a.KeyColumns, &a.ArchiveTable, &a.ArchivedAt, &a.ArchivedBy); err != nil {
+			return nil, err
+		}
+		archives = append(archives, a)
+	}
+	return archives, rows.Err()
+}
+
+// GetArchive returns an archive by id, or nil if there is none
+func GetArchive(ctx context.Context, conn *pgx.Conn, id int) (*Archive, error) {
+	archives, err := ListArchives(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	for i := range archives {
+		if archives[i].ID == id {
+			return &archives[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// RestoreArchive puts an archived table back under its name, or adds an
+// archived column back to its table and refills it by primary key. The
+// archive is removed afterwards. Column defaults and constraints are not
+// restored.
+func RestoreArchive(ctx context.Context, conn *pgx.Conn, a *Archive, reason string) error {
+	var stmts []string
+	switch a.Kind {
+	case ArchiveTable:
+		stmts = []string{
+			fmt.Sprintf("ALTER TABLE %s SET SCHEMA %s", quoteTable(ArchiveSchema, a.ArchiveTable), quoteIdent(a.Schema)),
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteTable(a.Schema, a.ArchiveTable), quoteIdent(a.Table)),
+		}
+
+	case ArchiveColumn:
+		var dataType string
+		err := conn.QueryRow(ctx, `
+			SELECT format_type(a.atttypid, a.atttypmod)
+			FROM pg_attribute a
+			WHERE a.attrelid = $1::regclass AND a.attname = $2 AND NOT a.attisdropped
+		`, quoteTable(ArchiveSchema, a.ArchiveTable), a.Column).Scan(&dataType)
+		if err != nil {
+			return fmt.Errorf("cannot read archived column type: %w", err)
+		}
+
+		var match []string
+		for _, k := range a.KeyColumns {
+			match = append(match, fmt.Sprintf("t.%s = a.%s", quoteIdent(k), quoteIdent(k)))
+		}
+		table := quoteTable(a.Schema, a.Table)
+		stmts = []string{
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, quoteIdent(a.Column), dataType),
+			fmt.Sprintf("UPDATE %s t SET %s = a.%s FROM %s a WHERE %s", table, quoteIdent(a.Column),
+				quoteIdent(a.Column), quoteTable(ArchiveSchema, a.ArchiveTable), strings.Join(match, " AND ")),
+			fmt.Sprintf("DROP TABLE %s", quoteTable(ArchiveSchema, a.ArchiveTable)),
+		}
+
+	default:
+		return fmt.Errorf("unknown archive kind %q", a.Kind)
+	}
+
+	return runArchiveSQL(ctx, conn, a, stmts, reason)
+}
+
+// PurgeArchive drops an archive table for good
+func PurgeArchive(ctx context.Context, conn *pgx.Conn, a *Archive, reason string) error {
+	return runArchiveSQL(ctx, conn, a, []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteTable(ArchiveSchema, a.ArchiveTable)),
+	}, reason)
+}
+
+// runArchiveSQL runs statements through pgmigrate.dba_migrate() and removes
+// the archive record, in one transaction
+func runArchiveSQL(ctx context.Context, conn *pgx.Conn, a *Archive, stmts []string, reason string) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(ctx, "SELECT pgmigrate.dba_migrate($1, $2)", stmt, reason); err != nil {
+				return fmt.Errorf("archive %d: %w", a.ID, err)
+			}
+		}
+		_, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = $1",
+			quoteTable(StateSchema, archivesTable)), a.ID)
+		return err
+	})
+}
+
+// primaryKeyColumns returns the primary key columns of a table in order
+func primaryKeyColumns(ctx context.Context, conn querier, schema, table string) ([]string, error) {
+	var keys []string
+	err := conn.QueryRow(ctx, `
+		SELECT array_agg(a.attname ORDER BY k.ord)
+		FROM pg_index i
+		CROSS JOIN unnest(i.indkey) WITH ORDINALITY AS k(attnum, ord)
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+		WHERE i.indrelid = $1::regclass AND i.indisprimary
+	`, quoteTable(schema, table)).Scan(&keys)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("cannot read primary key of %s.%s: %w", schema, table, err)
+	}
+	return keys, nil
+}
+
+func ensureArchiveSchema(ctx context.Context, conn querier) error {
+	if _, err := conn.Exec(ctx, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", quoteIdent(ArchiveSchema))); err != nil {
+		return fmt.Errorf("cannot create %s: %w", ArchiveSchema, err)
+	}
+	return ensureStateTable(ctx, conn, archivesTable, `
+		id            serial PRIMARY KEY,
+		kind          text NOT NULL,
+		schema_name   text NOT NULL,
+		table_name    text NOT NULL,
+		column_name   text,
+		key_columns   text[],
+		archive_table text NOT NULL,
+		archived_at   timestamptz NOT NULL DEFAULT now(),
+		archived_by   text NOT NULL DEFAULT current_user`)
+}
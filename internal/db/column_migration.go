@@ -0,0 +1,497 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Column migration phases, in execution order
+const (
+	PhaseExpand   = "expand"
+	PhaseSync     = "sync"
+	PhaseBackfill = "backfill"
+	PhaseSwap     = "swap"
+	PhaseDrop     = "drop"
+)
+
+// ColumnMigrationPhases lists the phases of an expand/contract column
+// type change in the order they run
+var ColumnMigrationPhases = []string{PhaseExpand, PhaseSync, PhaseBackfill, PhaseSwap, PhaseDrop}
+
+const columnMigrationsTable = "column_migrations"
+
+const columnMigrationsDDL = `
+	schema_name text NOT NULL,
+	table_name text NOT NULL,
+	column_name text NOT NULL,
+	from_type text NOT NULL,
+	to_type text NOT NULL,
+	phase text NOT NULL DEFAULT '',
+	backfilled bigint NOT NULL DEFAULT 0,
+	started_at timestamptz NOT NULL DEFAULT now(),
+	updated_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (schema_name, table_name, column_name)`
+
+// ColumnMigration is the tracked state of an online column type change
+type ColumnMigration struct {
+	Schema     string `json:"schema"`
+	Table      string `json:"table"`
+	Column     string `json:"column"`
+	FromType   string `json:"from_type"`
+	ToType     string `json:"to_type"`
+	Phase      string `json:"phase"` // last completed phase, "" if none
+	Backfilled int64  `json:"backfilled"`
+	StartedAt  string `json:"started_at"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+// NextPhase returns the phase to run next, or "" when the migration is done
+func (m *ColumnMigration) NextPhase() string {
+	if m.Phase == "" {
+		return ColumnMigrationPhases[0]
+	}
+	for i, p := range ColumnMigrationPhases {
+		if p == m.Phase && i+1 < len(ColumnMigrationPhases) {
+			return ColumnMigrationPhases[i+1]
+		}
+	}
+	return ""
+}
+
+// previousPhase returns the phase completed before phase
+func previousPhase(phase string) string {
+	for i, p := range ColumnMigrationPhases {
+		if p == phase && i > 0 {
+			return ColumnMigrationPhases[i-1]
+		}
+	}
+	return ""
+}
+
+// shadowColumn holds the new type until the swap
+func (m *ColumnMigration) shadowColumn() string {
+	return m.Column + "__pgm_new"
+}
+
+// oldColumn holds the original data after the swap
+func (m *ColumnMigration) oldColumn() string {
+	return m.Column + "__pgm_old"
+}
+
+func (m *ColumnMigration) table() string {
+	return quoteTable(m.Schema, m.Table)
+}
+
+func (m *ColumnMigration) syncFunction() string {
+	return quoteTable(m.Schema, shortIdent("pgm_sync_"+m.Table+"_"+m.Column))
+}
+
+func (m *ColumnMigration) syncTrigger() string {
+	return quoteIdent(shortIdent("pgm_sync_" + m.Column))
+}
+
+// notNullCheck proves the incoming column has no NULLs before the swap
+func (m *ColumnMigration) notNullCheck() string {
+	return quoteIdent(shortIdent(m.Column + "__pgm_nn"))
+}
+
+// shortIdent shortens a name longer than an identifier may be, keeping it
+// unique with a hash of the full name. PostgreSQL would truncate it, so
+// names of long tables and columns could collide.
+func shortIdent(name string) string {
+	const maxIdent = 63
+	if len(name) <= maxIdent {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return name[:maxIdent-9] + "_" + hex.EncodeToString(sum[:4])
+}
+
+func (m *ColumnMigration) reason(phase string) string {
+	return fmt.Sprintf("migrate-column %s.%s.%s to %s: %s", m.Schema, m.Table, m.Column, m.ToType, phase)
+}
+
+// syncSQL installs a trigger copying from into to on every write
+func (m *ColumnMigration) syncSQL(from, to, toType string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s() RETURNS trigger LANGUAGE plpgsql AS $pgm$
+BEGIN
+    NEW.%s := NEW.%s::%s;
+    RETURN NEW;
+END
+$pgm$`, m.syncFunction(), quoteIdent(to), quoteIdent(from), toType),
+		fmt.Sprintf("CREATE TRIGGER %s BEFORE INSERT OR UPDATE ON %s FOR EACH ROW EXECUTE FUNCTION %s()",
+			m.syncTrigger(), m.table(), m.syncFunction()),
+	}
+}
+
+func (m *ColumnMigration) dropSyncSQL() []string {
+	return []string{
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", m.syncTrigger(), m.table()),
+		fmt.Sprintf("DROP FUNCTION IF EXISTS %s()", m.syncFunction()),
+	}
+}
+
+// GetColumnMigration returns the tracked migration of a column, or nil
+func GetColumnMigration(ctx context.Context, conn *pgx.Conn, schema, table, column string) (*ColumnMigration, error) {
+	exists, err := stateTableExists(ctx, conn, columnMigrationsTable)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	m := ColumnMigration{Schema: schema, Table: table, Column: column}
+	err = conn.QueryRow(ctx, fmt.Sprintf(`
+		SELECT from_type, to_type, phase, backfilled, started_at::text, updated_at::text
+		FROM %s
+		WHERE schema_name = $1 AND table_name = $2 AND column_name = $3
+	`, quoteTable(StateSchema, columnMigrationsTable)), schema, table, column).Scan(
+		&m.FromType, &m.ToType, &m.Phase, &m.Backfilled, &m.StartedAt, &m.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read column migration: %w", err)
+	}
+
+	return &m, nil
+}
+
+// StartColumnMigration records a new column type change, replacing a
+// completed migration of the same column. The current type is read from
+// the catalog.
+func StartColumnMigration(ctx context.Context, conn *pgx.Conn, schema, table, column, toType string) (*ColumnMigration, error) {
+	var fromType string
+	err := conn.QueryRow(ctx, `
+		SELECT format_type(a.atttypid, a.atttypmod)
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = $2 AND a.attname = $3
+		  AND a.attnum > 0 AND NOT a.attisdropped
+	`, schema, table, column).Scan(&fromType)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("column %s.%s.%s does not exist", schema, table, column)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read column type: %w", err)
+	}
+
+	if err := ensureStateTable(ctx, conn, columnMigrationsTable, columnMigrationsDDL); err != nil {
+		return nil, err
+	}
+	_, err = conn.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s (schema_name, table_name, column_name, from_type, to_type)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (schema_name, table_name, column_name) DO UPDATE
+		SET from_type = EXCLUDED.from_type, to_type = EXCLUDED.to_type, phase = '',
+		    backfilled = 0, started_at = now(), updated_at = now()
+	`, quoteTable(StateSchema, columnMigrationsTable)), schema, table, column, fromType, toType)
+	if err != nil {
+		return nil, fmt.Errorf("cannot record column migration: %w", err)
+	}
+
+	return GetColumnMigration(ctx, conn, schema, table, column)
+}
+
+// RunColumnMigrationPhase runs the next phase of a column migration.
+// DDL phases run in one transaction through pgmigrate.dba_migrate(); the
+// backfill commits one batch at a time and can be resumed after an
+// interruption. onBatch, if set, is called with the running row count.
+func RunColumnMigrationPhase(ctx context.Context, conn *pgx.Conn, m *ColumnMigration, batchSize int, onBatch func(int64)) error {
+	phase := m.NextPhase()
+
+	var stmts []string
+	switch phase {
+	case "":
+		return fmt.Errorf("column migration of %s.%s.%s is already complete", m.Schema, m.Table, m.Column)
+
+	case PhaseExpand:
+		stmts = []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s",
+			m.table(), quoteIdent(m.shadowColumn()), m.ToType)}
+
+	case PhaseSync:
+		stmts = m.syncSQL(m.Column, m.shadowColumn(), m.ToType)
+
+	case PhaseBackfill:
+		if err := m.backfill(ctx, conn, batchSize, onBatch); err != nil {
+			return err
+		}
+
+	case PhaseSwap:
+		stmts = append(stmts, fmt.Sprintf("LOCK TABLE %s IN ACCESS EXCLUSIVE MODE", m.table()))
+		stmts = append(stmts, m.dropSyncSQL()...)
+		stmts = append(stmts,
+			// Catch rows written between the backfill and the lock
+			fmt.Sprintf("UPDATE %s SET %s = %s::%s WHERE %s IS NULL AND %s IS NOT NULL",
+				m.table(), quoteIdent(m.shadowColumn()), quoteIdent(m.Column), m.ToType,
+				quoteIdent(m.shadowColumn()), quoteIdent(m.Column)),
+			fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s",
+				m.table(), quoteIdent(m.Column), quoteIdent(m.oldColumn())),
+			fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s",
+				m.table(), quoteIdent(m.shadowColumn()), quoteIdent(m.Column)),
+		)
+		copied, err := m.moveColumnProperties(ctx, conn, m.shadowColumn(), m.oldColumn(), m.ToType)
+		if err != nil {
+			return err
+		}
+		stmts = append(stmts, copied...)
+		// Keep the old column current so the swap can be rolled back
+		stmts = append(stmts, m.syncSQL(m.Column, m.oldColumn(), m.FromType)...)
+
+	case PhaseDrop:
+		dependents, err := m.oldColumnDependents(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if len(dependents) > 0 {
+			return fmt.Errorf("cannot drop %s.%s.%s: %s still depend on it. Recreate them on %s (or drop them), then run the drop phase again",
+				m.Schema, m.Table, m.oldColumn(), strings.Join(dependents, ", "), m.Column)
+		}
+		stmts = append(m.dropSyncSQL(), fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s",
+			m.table(), quoteIdent(m.oldColumn())))
+	}
+
+	return m.runPhaseSQL(ctx, conn, stmts, phase, phase)
+}
+
+// RollbackColumnMigrationPhase undoes the last completed phase. The drop
+// phase cannot be rolled back.
+func RollbackColumnMigrationPhase(ctx context.Context, conn *pgx.Conn, m *ColumnMigration) error {
+	var stmts []string
+	switch m.Phase {
+	case "":
+		return fmt.Errorf("column migration of %s.%s.%s has no completed phase to roll back", m.Schema, m.Table, m.Column)
+
+	case PhaseExpand:
+		stmts = []string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s",
+			m.table(), quoteIdent(m.shadowColumn()))}
+
+	case PhaseSync:
+		stmts = m.dropSyncSQL()
+
+	case PhaseBackfill:
+		// Backfilled values are kept up to date by the sync trigger and
+		// are simply refreshed when the backfill runs again
+
+	case PhaseSwap:
+		stmts = append(stmts, fmt.Sprintf("LOCK TABLE %s IN ACCESS EXCLUSIVE MODE", m.table()))
+		stmts = append(stmts, m.dropSyncSQL()...)
+		stmts = append(stmts,
+			fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s",
+				m.table(), quoteIdent(m.Column), quoteIdent(m.shadowColumn())),
+			fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s",
+				m.table(), quoteIdent(m.oldColumn()), quoteIdent(m.Column)),
+		)
+		moved, err := m.moveColumnProperties(ctx, conn, m.oldColumn(), m.shadowColumn(), m.FromType)
+		if err != nil {
+			return err
+		}
+		stmts = append(stmts, moved...)
+		stmts = append(stmts, m.syncSQL(m.Column, m.shadowColumn(), m.ToType)...)
+
+	case PhaseDrop:
+		return fmt.Errorf("the drop phase cannot be rolled back: the old column is gone")
+	}
+
+	if err := m.runPhaseSQL(ctx, conn, stmts, previousPhase(m.Phase), "rollback "+m.Phase); err != nil {
+		return err
+	}
+
+	// Forget a migration rolled back to the start
+	if m.Phase == "" {
+		_, err := conn.Exec(ctx, fmt.Sprintf(`
+			DELETE FROM %s WHERE schema_name = $1 AND table_name = $2 AND column_name = $3
+		`, quoteTable(StateSchema, columnMigrationsTable)), m.Schema, m.Table, m.Column)
+		if err != nil {
+			return fmt.Errorf("cannot clear column migration: %w", err)
+		}
+	}
+	return nil
+}
+
+// runPhaseSQL runs statements through dba_migrate and records newPhase as
+// the last completed phase, all in one transaction
+func (m *ColumnMigration) runPhaseSQL(ctx context.Context, conn *pgx.Conn, stmts []string, newPhase, label string) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", label, err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(ctx, "SELECT pgmigrate.dba_migrate($1, $2)", stmt, m.reason(label)); err != nil {
+			return fmt.Errorf("%s failed: %w", label, err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(`
+		UPDATE %s SET phase = $4, updated_at = now()
+		WHERE schema_name = $1 AND table_name = $2 AND column_name = $3
+	`, quoteTable(StateSchema, columnMigrationsTable)), m.Schema, m.Table, m.Column, newPhase)
+	if err != nil {
+		return fmt.Errorf("cannot record phase: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s failed: %w", label, err)
+	}
+
+	m.Phase = newPhase
+	return nil
+}
+
+// backfill copies existing values into the shadow column in batches, each
+// committed on its own so locks are held briefly
+func (m *ColumnMigration) backfill(ctx context.Context, conn *pgx.Conn, batchSize int, onBatch func(int64)) error {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	update := fmt.Sprintf(`
+		UPDATE %[1]s SET %[2]s = %[3]s::%[4]s
+		WHERE ctid = ANY(ARRAY(
+			SELECT ctid FROM %[1]s
+			WHERE %[2]s IS NULL AND %[3]s IS NOT NULL
+			LIMIT %[5]d
+		))`, m.table(), quoteIdent(m.shadowColumn()), quoteIdent(m.Column), m.ToType, batchSize)
+	record := fmt.Sprintf(`
+		UPDATE %s SET backfilled = backfilled + $4, updated_at = now()
+		WHERE schema_name = $1 AND table_name = $2 AND column_name = $3
+	`, quoteTable(StateSchema, columnMigrationsTable))
+
+	for {
+		start := time.Now()
+		tag, err := conn.Exec(ctx, update)
+		if err != nil {
+			return fmt.Errorf("backfill failed: %w", err)
+		}
+
+		rows := tag.RowsAffected()
+		if rows == 0 {
+			return nil
+		}
+
+		if _, err := conn.Exec(ctx, record, m.Schema, m.Table, m.Column, rows); err != nil {
+			return fmt.Errorf("cannot record backfill progress: %w", err)
+		}
+		m.Backfilled += rows
+		if onBatch != nil {
+			onBatch(m.Backfilled)
+		}
+
+		// Give other writers room between batches
+		if err := sleep(ctx, time.Since(start)/10); err != nil {
+			return fmt.Errorf("backfill interrupted: %w", err)
+		}
+	}
+}
+
+// moveColumnProperties returns statements that move the default and NOT
+// NULL constraint of the live column to incoming, the column it is swapped
+// with. They run after the renames: the live column's current properties
+// end up on the column named m.Column, cast to toType, and are removed from
+// previous. For NOT NULL, a CHECK on incoming is added NOT VALID and
+// validated first, each committed on its own, so setting NOT NULL under the
+// swap's exclusive lock does not scan the table.
+func (m *ColumnMigration) moveColumnProperties(ctx context.Context, conn *pgx.Conn, incoming, previous, toType string) ([]string, error) {
+	var notNull bool
+	var def *string
+	err := conn.QueryRow(ctx, `
+		SELECT a.attnotnull, pg_get_expr(d.adbin, d.adrelid)
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE n.nspname = $1 AND c.relname = $2 AND a.attname = $3
+	`, m.Schema, m.Table, m.Column).Scan(&notNull, &def)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read column properties: %w", err)
+	}
+
+	var stmts []string
+	if def != nil {
+		stmts = append(stmts,
+			fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", m.table(), quoteIdent(previous)),
+			fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT (%s)::%s",
+				m.table(), quoteIdent(m.Column), *def, toType))
+	}
+	if notNull {
+		if err := m.validateNotNull(ctx, conn, incoming); err != nil {
+			return nil, err
+		}
+		stmts = append(stmts,
+			fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL", m.table(), quoteIdent(previous)),
+			fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", m.table(), quoteIdent(m.Column)),
+			fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", m.table(), m.notNullCheck()))
+	}
+	return stmts, nil
+}
+
+// validateNotNull adds CHECK (column IS NOT NULL) NOT VALID and validates
+// it, each statement committed on its own so writes only wait for the
+// brief lock of the first. A check left by an interrupted run is replaced.
+func (m *ColumnMigration) validateNotNull(ctx context.Context, conn *pgx.Conn, column string) error {
+	stmts := []string{
+		fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s", m.table(), m.notNullCheck()),
+		fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s CHECK (%s IS NOT NULL) NOT VALID",
+			m.table(), m.notNullCheck(), quoteIdent(column)),
+		fmt.Sprintf("ALTER TABLE %s VALIDATE CONSTRAINT %s", m.table(), m.notNullCheck()),
+	}
+	for _, stmt := range stmts {
+		if _, err := conn.Exec(ctx, "SELECT pgmigrate.dba_migrate($1, $2)", stmt, m.reason("validate not null")); err != nil {
+			return fmt.Errorf("cannot validate NOT NULL of %s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// oldColumnDependents lists the indexes, constraints and views that still
+// use the old column after the swap. Dropping the column would silently
+// drop the indexes and constraints, and fails for views.
+func (m *ColumnMigration) oldColumnDependents(ctx context.Context, conn *pgx.Conn) ([]string, error) {
+	rows, err := conn.Query(ctx, `
+		WITH col AS (
+			SELECT a.attrelid AS rel, a.attnum AS num
+			FROM pg_attribute a
+			WHERE a.attrelid = to_regclass($1) AND a.attname = $2 AND NOT a.attisdropped
+		)
+		SELECT 'index ' || d.objid::regclass::text
+		FROM pg_depend d JOIN col ON d.refobjid = col.rel AND d.refobjsubid = col.num
+		JOIN pg_index i ON i.indexrelid = d.objid
+		WHERE d.classid = 'pg_class'::regclass AND d.refclassid = 'pg_class'::regclass
+		UNION
+		SELECT 'constraint ' || quote_ident(con.conname) || ' on ' || con.conrelid::regclass::text
+		FROM pg_constraint con JOIN col
+		  ON (con.conrelid = col.rel AND col.num = ANY(con.conkey))
+		  OR (con.confrelid = col.rel AND col.num = ANY(con.confkey))
+		WHERE con.contype <> 'n'
+		UNION
+		SELECT 'view ' || r.ev_class::regclass::text
+		FROM pg_depend d JOIN col ON d.refobjid = col.rel AND d.refobjsubid = col.num
+		JOIN pg_rewrite r ON r.oid = d.objid
+		WHERE d.classid = 'pg_rewrite'::regclass AND d.refclassid = 'pg_class'::regclass
+		  AND r.ev_class <> col.rel
+		ORDER BY 1
+	`, m.table(), m.oldColumn())
+	if err != nil {
+		return nil, fmt.Errorf("cannot read objects using %s: %w", m.oldColumn(), err)
+	}
+	defer rows.Close()
+
+	var dependents []string
+	for rows.Next() {
+		var object string
+		if err := rows.Scan(&object); err != nil {
+			return nil, err
+		}
+		dependents = append(dependents, object)
+	}
+	return dependents, rows.Err()
+}
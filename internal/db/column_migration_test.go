@@ -0,0 +1,28 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSyncNamesFitIdentifiers(t *testing.T) {
+	long := strings.Repeat("c", 60)
+	a := &ColumnMigration{Schema: "public", Table: "orders", Column: long + "_a"}
+	b := &ColumnMigration{Schema: "public", Table: "orders", Column: long + "_b"}
+
+	for _, name := range []string{
+		shortIdent("pgm_sync_" + a.Table + "_" + a.Column),
+		shortIdent("pgm_sync_" + a.Column),
+		shortIdent(a.Column + "__pgm_nn"),
+	} {
+		if len(name) > 63 {
+			t.Errorf("%q is %d bytes, longer than an identifier", name, len(name))
+		}
+	}
+	if a.syncFunction() == b.syncFunction() || a.syncTrigger() == b.syncTrigger() {
+		t.Errorf("columns differing after the truncation point share sync names")
+	}
+	if got := shortIdent("pgm_sync_email"); got != "pgm_sync_email" {
+		t.Errorf("short name changed to %q", got)
+	}
+}
@@ -2,35 +2,90 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 )
 
-// Connect establishes a connection to PostgreSQL using DATABASE_URL
-func Connect(databaseURL string) (*pgx.Conn, error) {
-	if databaseURL == "" {
-		databaseURL = os.Getenv("DATABASE_URL")
+// ErrExtensionNotInstalled is returned when pg_migrate is missing from the database
+var ErrExtensionNotInstalled = errors.New("pg_migrate extension not installed. Run: CREATE EXTENSION pg_migrate")
+
+// Connect establishes a connection to PostgreSQL using the URL, or
+// DATABASE_URL, or the PG* environment variables
+func Connect(ctx context.Context, databaseURL string, opts ConnectOptions) (*pgx.Conn, error) {
+	config, err := parseConfig(ctx, databaseURL, opts)
+	if err != nil {
+		return nil, err
 	}
+	return connectConfig(ctx, config)
+}
 
-	if databaseURL == "" {
-		return nil, fmt.Errorf("DATABASE_URL not set. Set it or use --database-url flag")
+// parseConfig parses the connection string and applies the credentials of
+// opts to it
+func parseConfig(ctx context.Context, databaseURL string, opts ConnectOptions) (*pgx.ConnConfig, error) {
+	if opts.PasswordFile != "" && opts.PasswordCommand != "" {
+		return nil, fmt.Errorf("a password file and a password command cannot be combined")
 	}
 
-	ctx := context.Background()
-	conn, err := pgx.Connect(ctx, databaseURL)
+	connString, source, err := resolveConnString(databaseURL, opts)
 	if err != nil {
-		return nil, fmt.Errorf("connection failed: %w", err)
+		return nil, err
+	}
+	settings := newConnSettings(connString, source)
+
+	connString, err = withTLSFiles(connString, opts)
+	if err != nil {
+		return nil, err
+	}
+	config, err := pgx.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database URL: %w", err)
+	}
+
+	password := config.Password
+	if opts.PasswordFile != "" || opts.PasswordCommand != "" {
+		if config.Password, err = readPassword(ctx, opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Log != nil {
+		logSources(config, settings, opts, password)
 	}
 
+	// Identify ourselves in pg_stat_activity unless the URL already does
+	if config.RuntimeParams["application_name"] == "" {
+		config.RuntimeParams["application_name"] = "pgmigrate"
+	}
+	return config, nil
+}
+
+func connectConfig(ctx context.Context, config *pgx.ConnConfig) (*pgx.Conn, error) {
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("connection failed: %w", err)
+	}
 	return conn, nil
 }
 
-// CheckExtension verifies pg_migrate extension is installed
-func CheckExtension(conn *pgx.Conn) error {
-	ctx := context.Background()
+// closeTimeout bounds how long Close waits for an interrupted query to be
+// cancelled on the server
+const closeTimeout = 15 * time.Second
+
+// Close closes the connection. When a query was interrupted by its context,
+// pgx asks the server to cancel it in the background; Close waits for that,
+// so the process does not exit while the server keeps running the query.
+func Close(conn *pgx.Conn) {
+	conn.Close(context.Background())
+	select {
+	case <-conn.PgConn().CleanupDone():
+	case <-time.After(closeTimeout):
+	}
+}
 
+// CheckExtension verifies pg_migrate extension is installed
+func CheckExtension(ctx context.Context, conn *pgx.Conn) error {
 	var exists bool
 	err := conn.QueryRow(ctx,
 		"SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'pg_migrate')").Scan(&exists)
@@ -39,16 +94,14 @@ func CheckExtension(conn *pgx.Conn) error {
 	}
 
 	if !exists {
-		return fmt.Errorf("pg_migrate extension not installed. Run: CREATE EXTENSION pg_migrate")
+		return ErrExtensionNotInstalled
 	}
 
 	return nil
 }
 
 // GetExtensionVersion returns the pg_migrate extension version
-func GetExtensionVersion(conn *pgx.Conn) (string, error) {
-	ctx := context.Background()
-
+func GetExtensionVersion(ctx context.Context, conn *pgx.Conn) (string, error) {
 	var version string
 	err := conn.QueryRow(ctx,
 		"SELECT extversion FROM pg_extension WHERE extname = 'pg_migrate'").Scan(&version)
@@ -0,0 +1,312 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgservicefile"
+	"github.com/jackc/pgx/v5"
+)
+
+// ConnectOptions supply credentials kept out of the connection URL
+type ConnectOptions struct {
+	// PasswordFile and PasswordCommand read the password from a file, or
+	// from the output of a shell command. Either overrides any password in
+	// the URL, the environment or a passfile.
+	PasswordFile    string
+	PasswordCommand string
+
+	// TLSCert and TLSKey are the client certificate and key, and TLSRootCA
+	// the CA certificate the server's certificate is checked against, as
+	// PEM files
+	TLSCert   string
+	TLSKey    string
+	TLSRootCA string
+
+	// Source says where the database URL came from, such as --database-url
+	Source string
+
+	// Log, if set, gets one line for each connection setting saying where
+	// it came from. Secrets are never passed to it.
+	Log func(string)
+}
+
+// pgEnvVars are the libpq variables that can describe a connection
+// without any URL
+var pgEnvVars = []string{"PGHOST", "PGHOSTADDR", "PGPORT", "PGDATABASE", "PGUSER", "PGSERVICE"}
+
+// resolveConnString picks the connection string: the URL given, else
+// DATABASE_URL, else the PG* environment variables alone. It returns the
+// string and where it came from.
+func resolveConnString(databaseURL string, opts ConnectOptions) (string, string, error) {
+	if databaseURL != "" {
+		source := opts.Source
+		if source == "" {
+			source = "connection URL"
+		}
+		return databaseURL, source, nil
+	}
+	if v := os.Getenv("DATABASE_URL"); v != "" {
+		return v, "DATABASE_URL", nil
+	}
+	for _, name := range pgEnvVars {
+		if os.Getenv(name) != "" {
+			return "", "PG* environment variables", nil
+		}
+	}
+	return "", "", fmt.Errorf("DATABASE_URL not set. Set it, set PGHOST or PGSERVICE, or use --database-url flag")
+}
+
+// withTLSFiles adds the TLS flags to the connection string, so pgx builds
+// its TLS configuration from them as if they were part of the URL
+func withTLSFiles(connString string, opts ConnectOptions) (string, error) {
+	params := [][2]string{
+		{"sslcert", opts.TLSCert},
+		{"sslkey", opts.TLSKey},
+		{"sslrootcert", opts.TLSRootCA},
+	}
+
+	if isURL(connString) {
+		u, err := url.Parse(connString)
+		if err != nil {
+			return "", fmt.Errorf("invalid database URL: %w", err)
+		}
+		q := u.Query()
+		for _, p := range params {
+			if p[1] != "" {
+				q.Set(p[0], p[1])
+			}
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	for _, p := range params {
+		if p[1] != "" {
+			value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(p[1])
+			connString = strings.TrimSpace(connString + " " + p[0] + "='" + value + "'")
+		}
+	}
+	return connString, nil
+}
+
+// readPassword returns the password from --password-file or
+// --password-command, without its trailing newline. The command is killed
+// when ctx is done.
+func readPassword(ctx context.Context, opts ConnectOptions) (string, error) {
+	if opts.PasswordFile != "" {
+		content, err := os.ReadFile(opts.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("cannot read password file: %w", err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", opts.PasswordCommand)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("password command failed: %w", err)
+	}
+	password := strings.TrimRight(stdout.String(), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password command printed nothing")
+	}
+	return password, nil
+}
+
+// connSettings tells where each connection setting came from. Like libpq,
+// the connection string wins over the service file, which wins over the
+// environment.
+type connSettings struct {
+	source  string
+	conn    map[string]string
+	service string
+	servSet map[string]string
+}
+
+func newConnSettings(connString, source string) *connSettings {
+	s := &connSettings{source: source, conn: connStringSettings(connString)}
+
+	s.service = s.conn["service"]
+	if s.service == "" {
+		s.service = os.Getenv("PGSERVICE")
+	}
+	if s.service != "" {
+		file := s.serviceFile()
+		if sf, err := pgservicefile.ReadServicefile(file); err == nil {
+			if svc, err := sf.GetService(s.service); err == nil {
+				s.servSet = map[string]string{}
+				for k, v := range svc.Settings {
+					if k == "dbname" {
+						k = "database"
+					}
+					s.servSet[k] = v
+				}
+			}
+		}
+	}
+	return s
+}
+
+// serviceFile returns the pg_service.conf path pgx reads
+func (s *connSettings) serviceFile() string {
+	if f := s.conn["servicefile"]; f != "" {
+		return f
+	}
+	if f := os.Getenv("PGSERVICEFILE"); f != "" {
+		return f
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".pg_service.conf")
+}
+
+// from returns where a setting came from, or "" if it was not set
+func (s *connSettings) from(key, envVar string) string {
+	if _, ok := s.conn[key]; ok {
+		return s.source
+	}
+	if _, ok := s.servSet[key]; ok {
+		return "service " + s.service
+	}
+	if os.Getenv(envVar) != "" {
+		return "$" + envVar
+	}
+	return ""
+}
+
+// logSources reports where each credential came from
+func logSources(config *pgx.ConnConfig, s *connSettings, opts ConnectOptions, password string) {
+	log := opts.Log
+	log(fmt.Sprintf("connection settings from %s", s.source))
+	if s.service != "" {
+		how := "found"
+		if s.servSet == nil {
+			how = "not found"
+		}
+		log(fmt.Sprintf("service %s: %s in %s", s.service, how, s.serviceFile()))
+	}
+
+	orDefault := func(from string) string {
+		if from == "" {
+			return "default"
+		}
+		return from
+	}
+	log(fmt.Sprintf("host: %s (%s)", config.Host, orDefault(s.from("host", "PGHOST"))))
+	log(fmt.Sprintf("database: %s (%s)", config.Database, orDefault(s.from("database", "PGDATABASE"))))
+	log(fmt.Sprintf("user: %s (%s)", config.User, orDefault(s.from("user", "PGUSER"))))
+
+	switch {
+	case opts.PasswordFile != "":
+		log(fmt.Sprintf("password: from --password-file %s", opts.PasswordFile))
+	case opts.PasswordCommand != "":
+		log("password: from --password-command")
+	case s.from("password", "PGPASSWORD") != "":
+		log(fmt.Sprintf("password: from %s", s.from("password", "PGPASSWORD")))
+	case password != "":
+		log("password: from passfile " + passfile(s))
+	default:
+		log("password: none")
+	}
+
+	if from := s.from("sslmode", "PGSSLMODE"); from != "" {
+		log(fmt.Sprintf("sslmode: %s (%s)", settingValue(s, "sslmode", "PGSSLMODE"), from))
+	}
+	files := []struct {
+		name, key, env, flag, value string
+	}{
+		{"TLS client cert", "sslcert", "PGSSLCERT", "--tls-cert", opts.TLSCert},
+		{"TLS client key", "sslkey", "PGSSLKEY", "--tls-key", opts.TLSKey},
+		{"TLS root CA", "sslrootcert", "PGSSLROOTCERT", "--tls-root-ca", opts.TLSRootCA},
+	}
+	for _, f := range files {
+		if f.value != "" {
+			log(fmt.Sprintf("%s: %s (%s)", f.name, f.value, f.flag))
+		} else if from := s.from(f.key, f.env); from != "" {
+			log(fmt.Sprintf("%s: %s (%s)", f.name, settingValue(s, f.key, f.env), from))
+		}
+	}
+}
+
+// settingValue returns the value of a setting from the layer it came from
+func settingValue(s *connSettings, key, envVar string) string {
+	if v, ok := s.conn[key]; ok {
+		return v
+	}
+	if v, ok := s.servSet[key]; ok {
+		return v
+	}
+	return os.Getenv(envVar)
+}
+
+// passfile returns the .pgpass path pgx reads
+func passfile(s *connSettings) string {
+	if from := s.from("passfile", "PGPASSFILE"); from != "" {
+		return settingValue(s, "passfile", "PGPASSFILE")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".pgpass")
+}
+
+// dsnSetting matches one key=value pair of a keyword/value connection string
+var dsnSetting = regexp.MustCompile(`(\w+)\s*=\s*('(?:[^'\\]|\\.)*'|\S+)`)
+
+// connStringSettings returns the settings written in a connection string,
+// in either URL or keyword/value form. Names follow pgx: dbname is
+// database.
+func connStringSettings(connString string) map[string]string {
+	settings := map[string]string{}
+	if isURL(connString) {
+		u, err := url.Parse(connString)
+		if err != nil {
+			return settings
+		}
+		if u.User != nil {
+			if name := u.User.Username(); name != "" {
+				settings["user"] = name
+			}
+			if password, ok := u.User.Password(); ok {
+				settings["password"] = password
+			}
+		}
+		if u.Hostname() != "" {
+			settings["host"] = u.Hostname()
+		}
+		if u.Port() != "" {
+			settings["port"] = u.Port()
+		}
+		if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+			settings["database"] = db
+		}
+		for k, v := range u.Query() {
+			settings[k] = v[0]
+		}
+	} else {
+		for _, m := range dsnSetting.FindAllStringSubmatch(connString, -1) {
+			value := m[2]
+			if strings.HasPrefix(value, "'") {
+				value = strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(value[1 : len(value)-1])
+			}
+			settings[m[1]] = value
+		}
+	}
+
+	if v, ok := settings["dbname"]; ok {
+		settings["database"] = v
+		delete(settings, "dbname")
+	}
+	return settings
+}
+
+func isURL(connString string) bool {
+	return strings.HasPrefix(connString, "postgres://") || strings.HasPrefix(connString, "postgresql://")
+}
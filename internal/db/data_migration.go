@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// dataMigrationsTable records which data migrations have run
+const dataMigrationsTable = "data_migrations"
+
+// DataMigration is a rendered data migration script
+type DataMigration struct {
+	Name     string `json:"name"`
+	After    string `json:"after,omitempty"` // change key, e.g. "add_column public.users.slug"
+	Checksum string `json:"checksum"`
+	SQL      string `json:"sql"`
+}
+
+// PendingDataMigrations returns the migrations that have not run yet, and
+// the names of those that ran with a different checksum than the script
+// has now
+func PendingDataMigrations(ctx context.Context, conn *pgx.Conn, migrations []DataMigration) ([]DataMigration, []string, error) {
+	if len(migrations) == 0 {
+		return nil, nil, nil
+	}
+	exists, err := stateTableExists(ctx, conn, dataMigrationsTable)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return migrations, nil, nil
+	}
+
+	rows, err := conn.Query(ctx, fmt.Sprintf("SELECT name, checksum FROM %s",
+		quoteTable(StateSchema, dataMigrationsTable)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read data migrations: %w", err)
+	}
+	done := map[string]string{}
+	for rows.Next() {
+		var name, checksum string
+		if err := rows.Scan(&name, &checksum); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		done[name] = checksum
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var pending []DataMigration
+	var changed []string
+	for _, m := range migrations {
+		checksum, ran := done[m.Name]
+		switch {
+		case !ran:
+			pending = append(pending, m)
+		case checksum != m.Checksum:
+			changed = append(changed, m.Name)
+		}
+	}
+	return pending, changed, nil
+}
+
+func ensureDataMigrationsTable(ctx context.Context, conn querier) error {
+	return ensureStateTable(ctx, conn, dataMigrationsTable, `
+		name       text PRIMARY KEY,
+		checksum   text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now(),
+		applied_by text NOT NULL DEFAULT current_user`)
+}
+
+// runDataMigration executes a script through pgmigrate.dba_migrate() and
+// marks it as run, in the caller's transaction
+func runDataMigration(ctx context.Context, tx pgx.Tx, m *DataMigration, reason string) error {
+	if _, err := tx.Exec(ctx, "SELECT pgmigrate.dba_migrate($1, $2)", m.SQL, reason+" data migration "+m.Name); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s (name, checksum) VALUES ($1, $2)",
+		quoteTable(StateSchema, dataMigrationsTable)), m.Name, m.Checksum)
+	return err
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Dry run statement statuses
+const (
+	DryRunOK      = "ok"
+	DryRunFailed  = "failed"
+	DryRunSkipped = "skipped"
+)
+
+// DryRunStatement is the outcome of one statement in a dry run
+type DryRunStatement struct {
+	Change     *Change `json:"change,omitempty"`
+	Script     string  `json:"script,omitempty"`
+	SQL        string  `json:"sql"`
+	Status     string  `json:"status"`
+	DurationMs int64   `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+	SQLState   string  `json:"sqlstate,omitempty"`
+}
+
+// DryRunResult is the outcome of a dry run
+type DryRunResult struct {
+	Statements []DryRunStatement `json:"statements"`
+	Skipped    []Change          `json:"skipped"` // destructive changes left out
+	Failed     int               `json:"failed"`
+	DurationMs int               `json:"duration_ms"`
+}
+
+// DryRun executes everything Apply would, in one transaction that is rolled
+// back at the end. Each statement runs under a savepoint, so a failure is
+// recorded and the remaining statements still run. Statements that cannot
+// run in a transaction (CONCURRENTLY) are skipped.
+//
+// The statements take the same locks as a real apply until the rollback.
+// The archive schema and state tables they need are created in the same
+// transaction, so a dry run leaves nothing behind.
+func DryRun(ctx context.Context, conn *pgx.Conn, plan *PlanResult, allowDestructive bool, opts ApplyOptions) (*DryRunResult, error) {
+	start := time.Now()
+
+	steps, skipped := planSteps(plan, allowDestructive, opts)
+	result := &DryRunResult{Statements: []DryRunStatement{}, Skipped: skipped}
+
+	if err := setSessionTimeouts(ctx, conn, opts); err != nil {
+		return nil, err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("dry run failed: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if opts.Archive {
+		if err := prepareArchives(ctx, tx, steps); err != nil {
+			return nil, err
+		}
+	}
+	for _, st := range steps {
+		if st.script != nil {
+			if err := ensureDataMigrationsTable(ctx, tx); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	for _, st := range steps {
+		if st.script != nil {
+			stmt := DryRunStatement{Script: st.script.Name, SQL: st.script.SQL}
+			dryRunStatement(ctx, tx, &stmt, func(sp pgx.Tx) error {
+				return runDataMigration(ctx, sp, st.script, st.reason)
+			})
+			result.add(stmt)
+			continue
+		}
+
+		change := st.change
+		stmts, err := st.statements()
+		if err != nil {
+			return nil, err
+		}
+		for _, sql := range stmts {
+			stmt := DryRunStatement{Change: &change, SQL: sql}
+			if change.Concurrently {
+				stmt.Status = DryRunSkipped
+				stmt.Error = "CONCURRENTLY cannot run inside a transaction"
+				result.add(stmt)
+				continue
+			}
+			dryRunStatement(ctx, tx, &stmt, func(sp pgx.Tx) error {
+				_, err := sp.Exec(ctx, "SELECT pgmigrate.dba_migrate($1, $2)", sql, st.reason)
+				return err
+			})
+			result.add(stmt)
+		}
+	}
+
+	if err := tx.Rollback(ctx); err != nil {
+		return nil, fmt.Errorf("dry run rollback failed: %w", err)
+	}
+
+	result.DurationMs = int(time.Since(start).Milliseconds())
+	return result, nil
+}
+
+// dryRunStatement runs fn under a savepoint and records how it went
+func dryRunStatement(ctx context.Context, tx pgx.Tx, stmt *DryRunStatement, fn func(pgx.Tx) error) {
+	start := time.Now()
+	err := pgx.BeginFunc(ctx, tx, fn)
+	stmt.DurationMs = time.Since(start).Milliseconds()
+
+	if err == nil {
+		stmt.Status = DryRunOK
+		return
+	}
+	stmt.Status = DryRunFailed
+	stmt.Error = err.Error()
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		stmt.SQLState = pgErr.Code
+	}
+}
+
+func (r *DryRunResult) add(stmt DryRunStatement) {
+	if stmt.Status == DryRunFailed {
+		r.Failed++
+	}
+	r.Statements = append(r.Statements, stmt)
+}
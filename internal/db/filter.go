@@ -0,0 +1,249 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ChangeFilter restricts a plan to changes on selected objects.
+// Patterns are "schema" or "schema.table" and may use shell wildcards.
+type ChangeFilter struct {
+	Targets  []string
+	Excludes []string
+}
+
+// IsEmpty returns true if the filter selects every change
+func (f ChangeFilter) IsEmpty() bool {
+	return len(f.Targets) == 0 && len(f.Excludes) == 0
+}
+
+// Validate checks that all patterns are well formed
+func (f ChangeFilter) Validate() error {
+	for _, p := range append(append([]string{}, f.Targets...), f.Excludes...) {
+		if p == "" || strings.Count(p, ".") > 1 {
+			return fmt.Errorf("invalid filter %q: expected schema or schema.table", p)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid filter %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Match returns true if the change passes the filter
+func (f ChangeFilter) Match(c Change) bool {
+	if len(f.Targets) > 0 && !MatchAny(f.Targets, c) {
+		return false
+	}
+	return !MatchAny(f.Excludes, c)
+}
+
+// MatchAny returns true if any pattern matches the object the change touches
+func MatchAny(patterns []string, c Change) bool {
+	for _, p := range patterns {
+		if matchPattern(p, c) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern string, c Change) bool {
+	schemaPat, tablePat, hasTable := strings.Cut(pattern, ".")
+	schema, table := c.ObjectSchema(), c.ObjectTable()
+
+	if ok, _ := path.Match(schemaPat, schema); !ok {
+		return false
+	}
+	if !hasTable {
+		return true
+	}
+	// Schema-level changes only match patterns covering the whole schema
+	if table == "" {
+		return tablePat == "*"
+	}
+	ok, _ := path.Match(tablePat, table)
+	return ok
+}
+
+// ObjectSchema returns the schema the change applies to
+func (c *Change) ObjectSchema() string {
+	switch c.Type {
+	case "create_schema", "drop_schema":
+		return c.Name
+	}
+	return c.Schema
+}
+
+// ObjectTable returns the table the change applies to, or "" for
+// schema-level changes
+func (c *Change) ObjectTable() string {
+	switch c.Type {
+	case "create_schema", "drop_schema":
+		return ""
+	}
+	return c.Table
+}
+
+// Key returns a short identifier for the change such as
+// "add_column public.users.email"
+func (c *Change) Key() string {
+	obj := c.ObjectSchema()
+	if t := c.ObjectTable(); t != "" {
+		obj += "." + t
+	}
+	switch {
+	case c.GetColumnName() != "":
+		obj += "." + c.GetColumnName()
+	case c.GetIndexName() != "":
+		obj = c.ObjectSchema() + "." + c.GetIndexName()
+	case c.Type == "drop_index":
+		obj = c.Schema + "." + c.Name
+	}
+	return c.Type + " " + obj
+}
+
+// DependsOn returns true if the change cannot be applied unless other
+// is applied as well
+func (c *Change) DependsOn(other Change) bool {
+	switch other.Type {
+	case "create_schema":
+		// Everything created inside a new schema needs the schema
+		return c.Type != "create_schema" && c.Type != "drop_schema" &&
+			c.Schema == other.Name
+	case "create_table":
+		if c.Type == "create_table" || c.Type == "drop_table" {
+			return c.references(other.Schema, other.Table)
+		}
+		return (c.Schema == other.Schema && c.Table == other.Table) ||
+			c.references(other.Schema, other.Table)
+	case "add_column":
+		col := other.GetColumnName()
+		if c.Schema != other.Schema || c.Table != other.Table {
+			return c.references(other.Schema, other.Table+"."+col)
+		}
+		switch c.Type {
+		case "create_index":
+			return c.indexUsesColumn(col)
+		case "alter_column_type", "alter_column_nullable", "alter_column_default":
+			return c.GetColumnName() == col
+		}
+	case "drop_table", "drop_column":
+		// A table can only be dropped once the foreign keys pointing at it
+		// are gone with their table or column
+		if c.Type == "drop_table" && other.references(c.Schema, c.Table) {
+			return true
+		}
+		// A schema can only be dropped once its tables are gone
+		return c.Type == "drop_schema" && c.Name == other.Schema
+	case "drop_index":
+		return c.Type == "drop_schema" && c.Name == other.Schema
+	}
+	return false
+}
+
+// references returns true if any foreign key the change introduces, or
+// drops with its table or column, points at target, given as
+// "schema.table" or "schema.table.column"
+func (c *Change) references(schema, target string) bool {
+	prefix := schema + "." + target
+	var refs []string
+	for _, col := range c.Columns {
+		if col.References != nil {
+			refs = append(refs, *col.References)
+		}
+	}
+	if (c.Type == "add_column" || c.Type == "drop_column") && c.Column != nil {
+		var def ColumnDefinition
+		if err := json.Unmarshal(c.Column, &def); err == nil && def.References != nil {
+			refs = append(refs, *def.References)
+		}
+	}
+	for _, ref := range refs {
+		if ref == prefix || strings.HasPrefix(ref, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Change) indexUsesColumn(col string) bool {
+	var def IndexDefinition
+	if err := json.Unmarshal(c.Index, &def); err != nil {
+		return false
+	}
+	for _, name := range def.Columns {
+		if name == col {
+			return true
+		}
+	}
+	return false
+}
+
+// DependencyError reports a selected change that needs an unselected one
+type DependencyError struct {
+	Change    Change
+	DependsOn Change
+}
+
+func (e *DependencyError) Error() string {
+	return fmt.Sprintf("%s depends on %s, which is not selected",
+		e.Change.Key(), e.DependsOn.Key())
+}
+
+// Filter returns a plan containing only the changes that pass the filter.
+// It fails if a selected change depends on one that was filtered out.
+func (p *PlanResult) Filter(f ChangeFilter) (*PlanResult, error) {
+	if f.IsEmpty() {
+		return p, nil
+	}
+	return p.Select(f.Match)
+}
+
+// Select returns a plan containing only the changes for which keep returns
+// true. It fails if a kept change depends on one that was dropped.
+func (p *PlanResult) Select(keep func(Change) bool) (*PlanResult, error) {
+	result := &PlanResult{}
+	var kept, dropped []Change
+
+	for _, bucket := range []struct {
+		in  []Change
+		out *[]Change
+	}{
+		{p.Safe, &result.Safe},
+		{p.Destructive, &result.Destructive},
+		{p.Breaking, &result.Breaking},
+	} {
+		for _, c := range bucket.in {
+			if keep(c) {
+				*bucket.out = append(*bucket.out, c)
+				kept = append(kept, c)
+			} else {
+				dropped = append(dropped, c)
+			}
+		}
+	}
+
+	for _, c := range kept {
+		for _, d := range dropped {
+			if c.DependsOn(d) {
+				return nil, &DependencyError{Change: c, DependsOn: d}
+			}
+		}
+	}
+
+	// Data migrations tied to a dropped change wait until it is applied
+	droppedKeys := map[string]bool{}
+	for _, d := range dropped {
+		droppedKeys[d.Key()] = true
+	}
+	for _, m := range p.DataMigrations {
+		if !droppedKeys[m.After] {
+			result.DataMigrations = append(result.DataMigrations, m)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestSelectKeepsReferencedTableWithItsReferences(t *testing.T) {
+	ref := "public.users.id"
+	dropUsers := Change{Type: "drop_table", Schema: "public", Table: "users", Safety: "destructive"}
+
+	tests := []struct {
+		name     string
+		excluded Change
+		wantErr  bool
+	}{
+		{name: "referencing table", wantErr: true, excluded: Change{
+			Type: "drop_table", Schema: "public", Table: "orders", Safety: "destructive",
+			Columns: []ColumnDefinition{{Name: "user_id", DataType: "bigint", References: &ref}}}},
+		{name: "referencing column", wantErr: true, excluded: Change{
+			Type: "drop_column", Schema: "public", Table: "orders", Safety: "destructive",
+			Column: column(t, ColumnDefinition{Name: "user_id", DataType: "bigint", References: &ref})}},
+		{name: "unrelated table", excluded: Change{
+			Type: "drop_table", Schema: "public", Table: "logs", Safety: "destructive"}},
+		{name: "column given by name", excluded: Change{
+			Type: "drop_column", Schema: "public", Table: "orders", Safety: "destructive",
+			Column: json.RawMessage(`"note"`)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &PlanResult{Destructive: []Change{dropUsers, tt.excluded}}
+			_, err := plan.Filter(ChangeFilter{Targets: []string{"public.users"}})
+
+			var depErr *DependencyError
+			if tt.wantErr {
+				if !errors.As(err, &depErr) {
+					t.Fatalf("got %v, want a dependency error", err)
+				}
+				if depErr.Change.Key() != dropUsers.Key() || depErr.DependsOn.Key() != tt.excluded.Key() {
+					t.Errorf("got %s depends on %s", depErr.Change.Key(), depErr.DependsOn.Key())
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
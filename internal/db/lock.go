@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// applyLockNamespace is the first key of the apply advisory lock. The
+// second key is hashtext(current_database()).
+const applyLockNamespace = 0x70676d69 // "pgmi"
+
+// lockPollInterval is how often a held lock is retried while waiting
+const lockPollInterval = 500 * time.Millisecond
+
+// LockHolder describes the session holding the apply lock
+type LockHolder struct {
+	PID             int    `json:"pid"`
+	ApplicationName string `json:"application_name"`
+	User            string `json:"user"`
+	ClientAddr      string `json:"client_addr,omitempty"`
+	StartedAt       string `json:"started_at"` // start of its transaction, or of its last query when idle
+	State           string `json:"state"`
+}
+
+// LockHeldError is returned when another session holds the apply lock
+type LockHeldError struct {
+	Holder *LockHolder
+}
+
+func (e *LockHeldError) Error() string {
+	if e.Holder == nil {
+		return "another pgmigrate apply is running on this database"
+	}
+	return fmt.Sprintf("another pgmigrate apply is running on this database (pid %d, %s, started %s)",
+		e.Holder.PID, e.Holder.ApplicationName, e.Holder.StartedAt)
+}
+
+// AcquireApplyLock takes the session-level advisory lock that serializes
+// applies against a database. If the lock is held, it retries until wait
+// has elapsed and then returns a *LockHeldError.
+func AcquireApplyLock(ctx context.Context, conn *pgx.Conn, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+
+	for {
+		var acquired bool
+		err := conn.QueryRow(ctx,
+			"SELECT pg_try_advisory_lock($1, hashtext(current_database()))",
+			applyLockNamespace).Scan(&acquired)
+		if err != nil {
+			return fmt.Errorf("cannot take apply lock: %w", err)
+		}
+		if acquired {
+			return nil
+		}
+
+		if time.Now().Add(lockPollInterval).After(deadline) {
+			holder, err := GetApplyLockHolder(ctx, conn)
+			if err != nil {
+				return err
+			}
+			return &LockHeldError{Holder: holder}
+		}
+		if err := sleep(ctx, lockPollInterval); err != nil {
+			return fmt.Errorf("cannot take apply lock: %w", err)
+		}
+	}
+}
+
+// ReleaseApplyLock releases the apply lock taken by AcquireApplyLock
+func ReleaseApplyLock(ctx context.Context, conn *pgx.Conn) error {
+	var released bool
+	err := conn.QueryRow(ctx,
+		"SELECT pg_advisory_unlock($1, hashtext(current_database()))",
+		applyLockNamespace).Scan(&released)
+	if err != nil {
+		return fmt.Errorf("cannot release apply lock: %w", err)
+	}
+
+	return nil
+}
+
+// GetApplyLockHolder returns the session holding the apply lock, or nil if
+// the lock is free
+func GetApplyLockHolder(ctx context.Context, conn *pgx.Conn) (*LockHolder, error) {
+	var h LockHolder
+	err := conn.QueryRow(ctx, `
+		SELECT a.pid, coalesce(a.application_name, ''), coalesce(a.usename, ''),
+		       coalesce(host(a.client_addr), ''), coalesce(coalesce(a.xact_start, a.query_start)::text, ''),
+		       coalesce(a.state, '')
+		FROM pg_locks l
+		JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory'
+		  AND l.granted
+		  AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+		  AND l.classid = $1::int4::oid
+		  AND l.objid = hashtext(current_database())::oid
+		  AND l.objsubid = 2
+	`, applyLockNamespace).Scan(&h.PID, &h.ApplicationName, &h.User,
+		&h.ClientAddr, &h.StartedAt, &h.State)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read apply lock: %w", err)
+	}
+
+	return &h, nil
+}
@@ -60,6 +60,14 @@ type Change struct {
 
 	// Index operations
 	Index json.RawMessage `json:"index,omitempty"` // IndexDefinition object
+
+	// Steps, if set, replaces the change's DDL with statements that are
+	// each committed on their own, outside the apply transaction
+	Steps []string `json:"steps,omitempty"`
+
+	// Concurrently marks index changes whose steps use CONCURRENTLY. Those
+	// cannot run inside a function, so they bypass pgmigrate.dba_migrate().
+	Concurrently bool `json:"concurrently,omitempty"`
 }
 
 // GetColumnName extracts the column name from the Column field
@@ -98,11 +106,22 @@ func (c *Change) GetIndexName() string {
 	return ""
 }
 
+// indexName returns the index a create_index or drop_index change affects
+func (c *Change) indexName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.GetIndexName()
+}
+
 // PlanResult represents the output of pgmigrate.plan()
 type PlanResult struct {
 	Safe        []Change `json:"safe"`
 	Destructive []Change `json:"destructive"`
 	Breaking    []Change `json:"breaking"`
+
+	// DataMigrations are the pending data migration scripts, set by the CLI
+	DataMigrations []DataMigration `json:"data_migrations,omitempty"`
 }
 
 // HasBreaking returns true if there are breaking changes
@@ -115,9 +134,10 @@ func (p *PlanResult) HasDestructive() bool {
 	return len(p.Destructive) > 0
 }
 
-// IsEmpty returns true if there are no changes
+// IsEmpty returns true if there are no changes and no pending data migrations
 func (p *PlanResult) IsEmpty() bool {
-	return len(p.Safe) == 0 && len(p.Destructive) == 0 && len(p.Breaking) == 0
+	return len(p.Safe) == 0 && len(p.Destructive) == 0 && len(p.Breaking) == 0 &&
+		len(p.DataMigrations) == 0
 }
 
 // SafeCount returns the number of safe changes
@@ -135,11 +155,31 @@ func (p *PlanResult) BreakingCount() int {
 	return len(p.Breaking)
 }
 
-// ApplyResult represents the output of pgmigrate.apply()
+// Len returns the total number of changes
+func (p *PlanResult) Len() int {
+	return len(p.Safe) + len(p.Destructive) + len(p.Breaking)
+}
+
+// ExecutionOrder returns all changes in the order they are applied: safe
+// changes first, then destructive ones, each in the order pg_migrate planned
+// them. Breaking changes are never applied automatically and come last.
+func (p *PlanResult) ExecutionOrder() []Change {
+	changes := make([]Change, 0, p.Len())
+	changes = append(changes, p.Safe...)
+	changes = append(changes, p.Destructive...)
+	changes = append(changes, p.Breaking...)
+	return changes
+}
+
+// ApplyResult represents the outcome of an apply
 type ApplyResult struct {
 	Applied    []Change `json:"applied"`
 	Skipped    []Change `json:"skipped"`
 	DurationMs int      `json:"duration_ms"`
+	Attempts   int      `json:"attempts"`
+
+	// DataMigrations lists the data migration scripts that ran
+	DataMigrations []string `json:"data_migrations,omitempty"`
 }
 
 // HistoryEntry represents a row from pgmigrate.get_history()
@@ -154,9 +194,7 @@ type HistoryEntry struct {
 }
 
 // Plan loads YAML and returns the migration plan
-func Plan(conn *pgx.Conn, yamlContent string) (*PlanResult, error) {
-	ctx := context.Background()
-
+func Plan(ctx context.Context, conn *pgx.Conn, yamlContent string) (*PlanResult, error) {
 	// Load YAML into session
 	var loaded bool
 	err := conn.QueryRow(ctx, "SELECT pgmigrate.load($1)", yamlContent).Scan(&loaded)
@@ -179,36 +217,28 @@ func Plan(conn *pgx.Conn, yamlContent string) (*PlanResult, error) {
 	return &result, nil
 }
 
-// Apply executes the migration
-func Apply(conn *pgx.Conn, yamlContent string, allowDestructive bool) (*ApplyResult, error) {
-	ctx := context.Background()
-
-	// Load YAML
-	var loaded bool
-	if err := conn.QueryRow(ctx, "SELECT pgmigrate.load($1)", yamlContent).Scan(&loaded); err != nil {
-		return nil, fmt.Errorf("load failed: %w", err)
+// DBAMigrate runs SQL through pgmigrate.dba_migrate(), which executes it
+// and records it in history with the given reason
+func DBAMigrate(ctx context.Context, conn *pgx.Conn, sql, reason string) error {
+	if _, err := conn.Exec(ctx, "SELECT pgmigrate.dba_migrate($1, $2)", sql, reason); err != nil {
+		return fmt.Errorf("dba_migrate failed: %w", err)
 	}
 
-	// Apply
-	var resultJSON []byte
-	err := conn.QueryRow(ctx,
-		"SELECT pgmigrate.apply($1)::text", allowDestructive).Scan(&resultJSON)
-	if err != nil {
-		return nil, fmt.Errorf("apply failed: %w", err)
-	}
+	return nil
+}
 
-	var result ApplyResult
-	if err := json.Unmarshal(resultJSON, &result); err != nil {
-		return nil, fmt.Errorf("parse result failed: %w", err)
+// CurrentDatabase returns the name of the connected database
+func CurrentDatabase(ctx context.Context, conn *pgx.Conn) (string, error) {
+	var name string
+	if err := conn.QueryRow(ctx, "SELECT current_database()").Scan(&name); err != nil {
+		return "", fmt.Errorf("cannot read database name: %w", err)
 	}
 
-	return &result, nil
+	return name, nil
 }
 
 // Dump exports schema as YAML
-func Dump(conn *pgx.Conn, schemas []string) (string, error) {
-	ctx := context.Background()
-
+func Dump(ctx context.Context, conn *pgx.Conn, schemas []string) (string, error) {
 	var yaml string
 	err := conn.QueryRow(ctx, "SELECT pgmigrate.dump($1)", schemas).Scan(&yaml)
 	if err != nil {
@@ -219,9 +249,7 @@ func Dump(conn *pgx.Conn, schemas []string) (string, error) {
 }
 
 // GetHistory returns migration history
-func GetHistory(conn *pgx.Conn, limit int) ([]HistoryEntry, error) {
-	ctx := context.Background()
-
+func GetHistory(ctx context.Context, conn *pgx.Conn, limit int) ([]HistoryEntry, error) {
 	rows, err := conn.Query(ctx, `
 		SELECT id, migration_type, yaml_hash, executed_sql,
 		       applied_at::text, applied_by, duration_ms
@@ -246,9 +274,7 @@ func GetHistory(conn *pgx.Conn, limit int) ([]HistoryEntry, error) {
 }
 
 // Clear clears the pending session state
-func Clear(conn *pgx.Conn) error {
-	ctx := context.Background()
-
+func Clear(ctx context.Context, conn *pgx.Conn) error {
 	var cleared bool
 	if err := conn.QueryRow(ctx, "SELECT pgmigrate.clear()").Scan(&cleared); err != nil {
 		return fmt.Errorf("clear failed: %w", err)
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// UseOnlineConstraints rewrites changes that would scan a table under an
+// exclusive lock into a low-lock sequence of statements, each committed on
+// its own:
+//
+//   - Setting NOT NULL adds a CHECK (col IS NOT NULL) NOT VALID constraint,
+//     validates it, sets NOT NULL (which then skips the scan) and drops the
+//     check. These changes move from Breaking to Safe.
+//   - Adding a column with a foreign key adds the column, then the
+//     constraint as NOT VALID, then validates it. The column then only
+//     exists once the transaction has committed, so this is skipped when a
+//     change or data migration that runs in the transaction uses it.
+//
+// The sequence is stored in Change.Steps. Call it after DataMigrations and
+// the concurrent indexes are set.
+func (p *PlanResult) UseOnlineConstraints() {
+	var breaking []Change
+	for _, c := range p.Breaking {
+		if steps, ok := p.onlineSteps(c); ok {
+			c.Steps = steps
+			c.Safety = "safe"
+			p.Safe = append(p.Safe, c)
+			continue
+		}
+		breaking = append(breaking, c)
+	}
+	p.Breaking = breaking
+
+	for i, c := range p.Safe {
+		if len(c.Steps) > 0 {
+			continue
+		}
+		if steps, ok := p.onlineSteps(c); ok {
+			p.Safe[i].Steps = steps
+		}
+	}
+}
+
+// UseConcurrentIndexes turns index changes into CREATE/DROP INDEX
+// CONCURRENTLY, run outside the apply transaction so writes to the table
+// are not blocked. With all set, every index change is affected; otherwise
+// only creates of the indexes in selected, keyed "schema.index".
+func (p *PlanResult) UseConcurrentIndexes(all bool, selected map[string]bool) {
+	for _, changes := range [][]Change{p.Safe, p.Destructive} {
+		for i := range changes {
+			c := &changes[i]
+			switch c.Type {
+			case "create_index":
+				if !all && !selected[c.Schema+"."+c.indexName()] {
+					continue
+				}
+				var idx IndexDefinition
+				if err := json.Unmarshal(c.Index, &idx); err != nil {
+					continue
+				}
+				c.Steps = []string{indexSQL(c.Schema, c.Table, idx, true)}
+
+			case "drop_index":
+				if !all {
+					continue
+				}
+				c.Steps = []string{fmt.Sprintf("DROP INDEX CONCURRENTLY %s",
+					quoteTable(c.Schema, c.indexName()))}
+
+			default:
+				continue
+			}
+			c.Concurrently = true
+		}
+	}
+}
+
+// onlineSteps returns the low-lock statements for a change, if it has any
+func (p *PlanResult) onlineSteps(c Change) ([]string, bool) {
+	table := quoteTable(c.Schema, c.Table)
+
+	switch c.Type {
+	case "alter_column_nullable":
+		if c.ToNullable == nil || *c.ToNullable {
+			return nil, false
+		}
+		col := quoteIdent(c.GetColumnName())
+		check := quoteIdent(notNullCheckName(c))
+		return []string{
+			fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s CHECK (%s IS NOT NULL) NOT VALID", table, check, col),
+			fmt.Sprintf("ALTER TABLE %s VALIDATE CONSTRAINT %s", table, check),
+			fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", table, col),
+			fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", table, check),
+		}, true
+
+	case "add_column":
+		var col ColumnDefinition
+		if err := json.Unmarshal(c.Column, &col); err != nil || col.References == nil {
+			return nil, false
+		}
+		if p.usesNewColumn(c, col.Name) {
+			return nil, false
+		}
+		ref := *col.References
+		col.References = nil
+		fkey := quoteIdent(foreignKeyName(c))
+		return []string{
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, columnSQL(col, true)),
+			fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s NOT VALID",
+				table, fkey, quoteIdent(col.Name), referenceSQL(ref)),
+			fmt.Sprintf("ALTER TABLE %s VALIDATE CONSTRAINT %s", table, fkey),
+		}, true
+	}
+
+	return nil, false
+}
+
+// usesNewColumn reports whether a change or data migration that runs in
+// the apply transaction uses the column an add_column change adds. Data
+// migrations placed after the change run after its steps, and others are
+// checked for the column name in their SQL.
+func (p *PlanResult) usesNewColumn(add Change, column string) bool {
+	for _, c := range p.ExecutionOrder() {
+		if len(c.Steps) > 0 || c.Schema != add.Schema || c.Table != add.Table || c.Key() == add.Key() {
+			continue
+		}
+		switch c.Type {
+		case "create_index":
+			var idx IndexDefinition
+			if err := json.Unmarshal(c.Index, &idx); err != nil {
+				return true
+			}
+			for _, col := range idx.Columns {
+				if col == column {
+					return true
+				}
+			}
+			if idx.Condition != nil && strings.Contains(*idx.Condition, column) {
+				return true
+			}
+		default:
+			if c.GetColumnName() == column {
+				return true
+			}
+		}
+	}
+
+	for _, m := range p.DataMigrations {
+		if m.After != add.Key() && strings.Contains(strings.ToLower(m.SQL), strings.ToLower(column)) {
+			return true
+		}
+	}
+	return false
+}
+
+// onlineCleanup returns statements that remove what a failed low-lock
+// sequence left behind, so the change can be retried from scratch
+func onlineCleanup(c Change) []string {
+	table := quoteTable(c.Schema, c.Table)
+	if c.Concurrently {
+		return nil
+	}
+
+	switch c.Type {
+	case "alter_column_nullable":
+		return []string{fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s",
+			table, quoteIdent(notNullCheckName(c)))}
+	case "add_column":
+		return []string{fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s",
+			table, quoteIdent(foreignKeyName(c)))}
+	}
+	return nil
+}
+
+// notNullCheckName names the temporary check. It must differ from the
+// <table>_<col>_not_null constraint PostgreSQL 18 creates for SET NOT NULL.
+func notNullCheckName(c Change) string {
+	return fmt.Sprintf("%s_%s_pgm_nn", c.Table, c.GetColumnName())
+}
+
+// foreignKeyName follows PostgreSQL's default naming for a column FK
+func foreignKeyName(c Change) string {
+	return fmt.Sprintf("%s_%s_fkey", c.Table, c.GetColumnName())
+}
+
+// dropInvalidIndex drops the index of a concurrent create_index if a
+// failed build left it INVALID. A valid index of that name is kept.
+func dropInvalidIndex(ctx context.Context, conn querier, c Change) error {
+	if c.Type != "create_index" {
+		return nil
+	}
+
+	var invalid bool
+	err := conn.QueryRow(ctx, `
+		SELECT NOT i.indisvalid
+		FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = $2
+	`, c.Schema, c.indexName()).Scan(&invalid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot check index %s.%s: %w", c.Schema, c.indexName(), err)
+	}
+	if !invalid {
+		return nil
+	}
+
+	if _, err := conn.Exec(ctx, fmt.Sprintf("DROP INDEX CONCURRENTLY IF EXISTS %s",
+		quoteTable(c.Schema, c.indexName()))); err != nil {
+		return fmt.Errorf("cannot drop invalid index %s.%s: %w", c.Schema, c.indexName(), err)
+	}
+	return nil
+}
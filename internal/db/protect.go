@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// protectedObjectsTable remembers objects marked protected in schema.yaml,
+// so they stay protected after they disappear from it
+const protectedObjectsTable = "protected_objects"
+
+// DroppedObject returns the object a destructive change drops, as
+// "schema", "schema.table" or "schema.table.column", or "" if it drops none
+func (c *Change) DroppedObject() string {
+	switch c.Type {
+	case "drop_schema":
+		return c.Name
+	case "drop_table":
+		return c.Schema + "." + c.Table
+	case "drop_column":
+		return c.Schema + "." + c.Table + "." + c.GetColumnName()
+	}
+	return ""
+}
+
+// DropRemoves returns true if dropping object removes what pattern names:
+// the object itself or, for a schema or table, anything inside it. Pattern
+// parts may use shell wildcards: "public.*" names every table in public,
+// "public.users.*" every column of public.users.
+func DropRemoves(object, pattern string) bool {
+	objParts, patParts := strings.Split(object, "."), strings.Split(pattern, ".")
+	if len(patParts) < len(objParts) {
+		return false
+	}
+	for i := range objParts {
+		if ok, _ := path.Match(patParts[i], objParts[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// GetProtectedObjects returns the objects recorded as protected
+func GetProtectedObjects(ctx context.Context, conn *pgx.Conn) (map[string]bool, error) {
+	protected := map[string]bool{}
+	exists, err := stateTableExists(ctx, conn, protectedObjectsTable)
+	if err != nil || !exists {
+		return protected, err
+	}
+
+	rows, err := conn.Query(ctx, fmt.Sprintf("SELECT object FROM %s",
+		quoteTable(StateSchema, protectedObjectsTable)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read protected objects: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var object string
+		if err := rows.Scan(&object); err != nil {
+			return nil, err
+		}
+		protected[object] = true
+	}
+	return protected, rows.Err()
+}
+
+// SetProtectedObjects records objects as protected (true) or removes their
+// protection (false)
+func SetProtectedObjects(ctx context.Context, conn *pgx.Conn, objects map[string]bool) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	if err := ensureProtectedObjectsTable(ctx, conn); err != nil {
+		return err
+	}
+
+	table := quoteTable(StateSchema, protectedObjectsTable)
+	for object, protected := range objects {
+		var err error
+		if protected {
+			_, err = conn.Exec(ctx, fmt.Sprintf(
+				"INSERT INTO %s (object) VALUES ($1) ON CONFLICT (object) DO NOTHING", table), object)
+		} else {
+			_, err = conn.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE object = $1", table), object)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot update protection of %s: %w", object, err)
+		}
+	}
+	return nil
+}
+
+func ensureProtectedObjectsTable(ctx context.Context, conn *pgx.Conn) error {
+	return ensureStateTable(ctx, conn, protectedObjectsTable, `
+		object       text PRIMARY KEY,
+		protected_at timestamptz NOT NULL DEFAULT now(),
+		protected_by text NOT NULL DEFAULT current_user`)
+}
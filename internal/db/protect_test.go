@@ -0,0 +1,29 @@
+package db
+
+import "testing"
+
+func TestDropRemoves(t *testing.T) {
+	tests := []struct {
+		object, pattern string
+		want            bool
+	}{
+		{"public.users", "public.users", true},
+		{"public.users", "public.orders", false},
+		{"public.users", "public.*", true},
+		{"public.users", "public.users.email", true},
+		{"public.users", "public.users.*", true},
+		{"public", "public.users", true},
+		{"public", "*", true},
+		{"public.users.email", "public.users.email", true},
+		{"public.users.email", "public.users.name", false},
+		{"public.users.email", "public.users", false},
+		{"public.users", "public", false},
+		{"audit", "public.*", false},
+	}
+
+	for _, tt := range tests {
+		if got := DropRemoves(tt.object, tt.pattern); got != tt.want {
+			t.Errorf("DropRemoves(%q, %q) = %v, want %v", tt.object, tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const schemaSnapshotsTable = "schema_snapshots"
+
+// SchemaSnapshot is the schema.yaml content of one apply, keyed by the
+// range of history entries the apply recorded
+type SchemaSnapshot struct {
+	FirstHistoryID int       `json:"first_history_id"`
+	LastHistoryID  int       `json:"last_history_id"`
+	YAMLHash       string    `json:"yaml_hash"`
+	YAML           string    `json:"-"`
+	SchemaFile     string    `json:"schema_file"`
+	AppliedAt      time.Time `json:"applied_at"`
+	AppliedBy      string    `json:"applied_by"`
+}
+
+// LatestHistoryID returns the id of the newest history entry, or 0.
+// get_history(n) returns the n newest entries, as 'pgmigrate history -n'
+// relies on, so the newest is the single entry of get_history(1).
+func LatestHistoryID(ctx context.Context, conn querier) (int, error) {
+	var id int
+	err := conn.QueryRow(ctx,
+		"SELECT coalesce((SELECT id FROM pgmigrate.get_history(1)), 0)").Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("get history failed: %w", err)
+	}
+	return id, nil
+}
+
+// RecordSchemaSnapshot stores the YAML of an apply whose history entries
+// came after afterID. An apply that recorded no history is not stored.
+func RecordSchemaSnapshot(ctx context.Context, conn *pgx.Conn, afterID int, schemaFile string, yamlContent []byte) error {
+	lastID, err := LatestHistoryID(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if lastID <= afterID {
+		return nil
+	}
+
+	if err := ensureSchemaSnapshotsTable(ctx, conn); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(yamlContent)
+	_, err = conn.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s (first_history_id, last_history_id, yaml_hash, yaml, schema_file)
+		VALUES ($1, $2, $3, $4, $5)
+	`, quoteTable(StateSchema, schemaSnapshotsTable)),
+		afterID+1, lastID, hex.EncodeToString(sum[:]), string(yamlContent), schemaFile)
+	if err != nil {
+		return fmt.Errorf("cannot store applied schema: %w", err)
+	}
+	return nil
+}
+
+// GetSchemaSnapshot returns the snapshot of the apply that recorded the
+// history entry, or nil if none was stored
+func GetSchemaSnapshot(ctx context.Context, conn *pgx.Conn, historyID int) (*SchemaSnapshot, error) {
+	exists, err := stateTableExists(ctx, conn, schemaSnapshotsTable)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	var s SchemaSnapshot
+	err = conn.QueryRow(ctx, fmt.Sprintf(`
+		SELECT first_history_id, last_history_id, yaml_hash, yaml, schema_file,
+		       applied_at, applied_by
+		FROM %s
+		WHERE $1 BETWEEN first_history_id AND last_history_id
+	`, quoteTable(StateSchema, schemaSnapshotsTable)), historyID).Scan(
+		&s.FirstHistoryID, &s.LastHistoryID, &s.YAMLHash, &s.YAML, &s.SchemaFile,
+		&s.AppliedAt, &s.AppliedBy)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read applied schema: %w", err)
+	}
+	return &s, nil
+}
+
+func ensureSchemaSnapshotsTable(ctx context.Context, conn *pgx.Conn) error {
+	return ensureStateTable(ctx, conn, schemaSnapshotsTable, `
+		first_history_id int NOT NULL,
+		last_history_id  int PRIMARY KEY,
+		yaml_hash        text NOT NULL,
+		yaml             text NOT NULL,
+		schema_file      text NOT NULL,
+		applied_at       timestamptz NOT NULL DEFAULT now(),
+		applied_by       text NOT NULL DEFAULT current_user`)
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TestLatestHistoryIDIsNewest checks against a database with the extension
+// that get_history(1) returns the newest entry. It needs
+// PGMIGRATE_TEST_DATABASE_URL and leaves no history behind.
+func TestLatestHistoryIDIsNewest(t *testing.T) {
+	url := os.Getenv("PGMIGRATE_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("PGMIGRATE_TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	last, err := LatestHistoryID(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := tx.Exec(ctx, "SELECT pgmigrate.dba_migrate($1, $2)",
+			"CREATE TEMP TABLE IF NOT EXISTS pgm_history_test (id int)", "history order test"); err != nil {
+			t.Fatal(err)
+		}
+		id, err := LatestHistoryID(ctx, tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= last {
+			t.Fatalf("latest history id %d after a new entry, was %d", id, last)
+		}
+		last = id
+	}
+}
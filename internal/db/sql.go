@@ -0,0 +1,164 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ChangeSQL renders the DDL statements that perform a change
+func ChangeSQL(c Change) ([]string, error) {
+	if len(c.Steps) > 0 {
+		return c.Steps, nil
+	}
+
+	switch c.Type {
+	case "create_schema":
+		return []string{fmt.Sprintf("CREATE SCHEMA %s", quoteIdent(c.Name))}, nil
+
+	case "drop_schema":
+		return []string{fmt.Sprintf("DROP SCHEMA %s", quoteIdent(c.Name))}, nil
+
+	case "create_table":
+		return createTableSQL(c), nil
+
+	case "drop_table":
+		return []string{fmt.Sprintf("DROP TABLE %s", quoteTable(c.Schema, c.Table))}, nil
+
+	case "add_column":
+		var col ColumnDefinition
+		if err := json.Unmarshal(c.Column, &col); err != nil {
+			return nil, fmt.Errorf("%s: cannot parse column definition: %w", c.Key(), err)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s",
+			quoteTable(c.Schema, c.Table), columnSQL(col, true))}, nil
+
+	case "drop_column":
+		return []string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s",
+			quoteTable(c.Schema, c.Table), quoteIdent(c.GetColumnName()))}, nil
+
+	case "alter_column_type":
+		if c.NewType == nil {
+			return nil, fmt.Errorf("%s: missing new type", c.Key())
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s",
+			quoteTable(c.Schema, c.Table), quoteIdent(c.GetColumnName()), *c.NewType)}, nil
+
+	case "alter_column_nullable":
+		action := "DROP NOT NULL"
+		if c.ToNullable != nil && !*c.ToNullable {
+			action = "SET NOT NULL"
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s %s",
+			quoteTable(c.Schema, c.Table), quoteIdent(c.GetColumnName()), action)}, nil
+
+	case "alter_column_default":
+		action := "DROP DEFAULT"
+		if c.NewDefault != nil {
+			action = "SET DEFAULT " + *c.NewDefault
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s %s",
+			quoteTable(c.Schema, c.Table), quoteIdent(c.GetColumnName()), action)}, nil
+
+	case "create_index":
+		var idx IndexDefinition
+		if err := json.Unmarshal(c.Index, &idx); err != nil {
+			return nil, fmt.Errorf("%s: cannot parse index definition: %w", c.Key(), err)
+		}
+		return []string{indexSQL(c.Schema, c.Table, idx, false)}, nil
+
+	case "drop_index":
+		return []string{fmt.Sprintf("DROP INDEX %s", quoteTable(c.Schema, c.indexName()))}, nil
+	}
+
+	return nil, fmt.Errorf("cannot generate SQL for change type %q", c.Type)
+}
+
+func createTableSQL(c Change) []string {
+	var defs, pk []string
+	for _, col := range c.Columns {
+		defs = append(defs, columnSQL(col, false))
+		if col.PrimaryKey {
+			pk = append(pk, quoteIdent(col.Name))
+		}
+	}
+	if len(pk) > 0 {
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(pk, ", ")))
+	}
+
+	stmts := []string{fmt.Sprintf("CREATE TABLE %s (\n    %s\n)",
+		quoteTable(c.Schema, c.Table), strings.Join(defs, ",\n    "))}
+	for _, idx := range c.Indexes {
+		stmts = append(stmts, indexSQL(c.Schema, c.Table, idx, false))
+	}
+	return stmts
+}
+
+// columnSQL renders a column definition. Primary keys are declared inline
+// only when the column is added on its own.
+func columnSQL(col ColumnDefinition, inlinePK bool) string {
+	parts := []string{quoteIdent(col.Name), col.DataType}
+	if col.PrimaryKey && inlinePK {
+		parts = append(parts, "PRIMARY KEY")
+	}
+	if col.NotNull || (col.Nullable != nil && !*col.Nullable) {
+		parts = append(parts, "NOT NULL")
+	}
+	if col.Default != nil {
+		parts = append(parts, "DEFAULT "+*col.Default)
+	}
+	if col.Unique {
+		parts = append(parts, "UNIQUE")
+	}
+	if col.References != nil {
+		parts = append(parts, "REFERENCES "+referenceSQL(*col.References))
+	}
+	return strings.Join(parts, " ")
+}
+
+// referenceSQL renders a "schema.table.column" reference
+func referenceSQL(ref string) string {
+	parts := strings.Split(ref, ".")
+	switch len(parts) {
+	case 3:
+		return fmt.Sprintf("%s (%s)", quoteTable(parts[0], parts[1]), quoteIdent(parts[2]))
+	case 2:
+		return quoteTable(parts[0], parts[1])
+	}
+	return quoteIdent(ref)
+}
+
+func indexSQL(schema, table string, idx IndexDefinition, concurrently bool) string {
+	var b strings.Builder
+	b.WriteString("CREATE ")
+	if idx.Unique {
+		b.WriteString("UNIQUE ")
+	}
+	b.WriteString("INDEX ")
+	if concurrently {
+		b.WriteString("CONCURRENTLY ")
+	}
+	fmt.Fprintf(&b, "%s ON %s", quoteIdent(idx.Name), quoteTable(schema, table))
+	if idx.Method != nil && *idx.Method != "" {
+		fmt.Fprintf(&b, " USING %s", *idx.Method)
+	}
+	cols := make([]string, len(idx.Columns))
+	for i, col := range idx.Columns {
+		cols[i] = quoteIdent(col)
+	}
+	fmt.Fprintf(&b, " (%s)", strings.Join(cols, ", "))
+	if idx.Condition != nil && *idx.Condition != "" {
+		fmt.Fprintf(&b, " WHERE %s", *idx.Condition)
+	}
+	return b.String()
+}
+
+func quoteIdent(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+func quoteTable(schema, table string) string {
+	return pgx.Identifier{schema, table}.Sanitize()
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// StateSchema holds the tables pgmigrate keeps in the database for
+// workflows the extension does not track itself
+const StateSchema = "pgmigrate_cli"
+
+// querier runs SQL on a connection or in a transaction
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// stateTableExists reports whether a state table has been created. Paths
+// that only read state treat a missing table as empty, so they leave the
+// database untouched.
+func stateTableExists(ctx context.Context, conn *pgx.Conn, table string) (bool, error) {
+	var exists bool
+	err := conn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL",
+		quoteTable(StateSchema, table)).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("cannot look up %s.%s: %w", StateSchema, table, err)
+	}
+	return exists, nil
+}
+
+// ensureStateTable creates the state schema and a table in it if needed.
+// ddl is the column list of the table.
+func ensureStateTable(ctx context.Context, conn querier, table, ddl string) error {
+	stmts := []string{
+		fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", quoteIdent(StateSchema)),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quoteTable(StateSchema, table), ddl),
+	}
+	for _, stmt := range stmts {
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("cannot create %s.%s: %w", StateSchema, table, err)
+		}
+	}
+	return nil
+}
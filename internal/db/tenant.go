@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ListSchemas returns the user schemas of the database, sorted by name.
+// System schemas and those pgmigrate keeps its own state in are left out.
+func ListSchemas(ctx context.Context, conn *pgx.Conn) ([]string, error) {
+	return querySchemas(ctx, conn, `
+		SELECT nspname::text
+		FROM pg_namespace
+		WHERE nspname NOT LIKE 'pg\_%'
+		  AND nspname NOT IN ('information_schema', 'pgmigrate', $1, $2)
+		ORDER BY nspname
+	`, StateSchema, ArchiveSchema)
+}
+
+// QuerySchemas runs a query that returns schema names in its first column
+func QuerySchemas(ctx context.Context, conn *pgx.Conn, query string) ([]string, error) {
+	names, err := querySchemas(ctx, conn, query)
+	if err != nil {
+		return nil, fmt.Errorf("tenant query failed: %w", err)
+	}
+	return names, nil
+}
+
+func querySchemas(ctx context.Context, conn *pgx.Conn, query string, args ...interface{}) ([]string, error) {
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list schemas: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("query returned no columns")
+		}
+		name, ok := values[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("schema names must be text, got %T", values[0])
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Backoff between connection attempts while waiting for the database
+const (
+	waitInitialBackoff = 250 * time.Millisecond
+	waitMaxBackoff     = 5 * time.Second
+)
+
+// WaitOptions control ConnectWait
+type WaitOptions struct {
+	// Timeout is how long to keep trying
+	Timeout time.Duration
+
+	// Extension also waits until the pg_migrate extension is installed
+	Extension bool
+
+	// OnRetry is called after each failed attempt, before waiting
+	OnRetry func(attempt int, wait time.Duration, err error)
+}
+
+// ConnectWait connects like Connect, retrying with exponential backoff
+// until the server accepts connections or opts.Timeout runs out. Errors a
+// retry cannot fix, such as a bad URL or password, fail at once.
+func ConnectWait(ctx context.Context, databaseURL string, connOpts ConnectOptions, opts WaitOptions) (*pgx.Conn, error) {
+	config, err := parseConfig(ctx, databaseURL, connOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(opts.Timeout)
+	waitCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	backoff := waitInitialBackoff
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		conn, err := connectConfig(waitCtx, config)
+		if err == nil && opts.Extension {
+			if err = CheckExtension(waitCtx, conn); err != nil {
+				Close(conn)
+			}
+		}
+		if err == nil {
+			return conn, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("waiting for database: %w", ctx.Err())
+		}
+		// An attempt cut short by the deadline says nothing new
+		if time.Until(deadline) > 0 || lastErr == nil {
+			lastErr = err
+		}
+		if !waitRetryable(err) {
+			return nil, err
+		}
+
+		wait := min(backoff, time.Until(deadline))
+		if wait <= 0 {
+			return nil, notReadyError(opts.Timeout, lastErr)
+		}
+		if opts.OnRetry != nil {
+			opts.OnRetry(attempt, wait, err)
+		}
+		if err := sleep(waitCtx, wait); err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("waiting for database: %w", ctx.Err())
+			}
+			return nil, notReadyError(opts.Timeout, lastErr)
+		}
+		backoff = min(backoff*2, waitMaxBackoff)
+	}
+}
+
+// waitRetryable returns false for errors waiting will not fix: the server
+// answered, but refused the credentials
+func waitRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "28") {
+		return false
+	}
+	return true
+}
+
+// notReadyError reports the last failure once the wait ran out
+func notReadyError(timeout time.Duration, err error) error {
+	if errors.Is(err, ErrExtensionNotInstalled) {
+		return fmt.Errorf("pg_migrate extension still not installed after %s: %w", timeout, err)
+	}
+	return fmt.Errorf("database not ready after %s: %v", timeout, err)
+}
@@ -0,0 +1,57 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EnvelopeVersion is the version of the JSON envelope format. It is bumped
+// whenever a field is removed or changes meaning.
+const EnvelopeVersion = 1
+
+// Envelope wraps the JSON output of every command
+type Envelope struct {
+	Version int            `json:"envelope_version"`
+	Command string         `json:"command"`
+	OK      bool           `json:"ok"`
+	Data    interface{}    `json:"data,omitempty"`
+	Error   *EnvelopeError `json:"error,omitempty"`
+}
+
+// EnvelopeError describes a failed command
+type EnvelopeError struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	SQLState string `json:"sqlstate,omitempty"`
+}
+
+// PrintEnvelope outputs a successful command result as JSON
+func PrintEnvelope(command string, data interface{}) error {
+	return printEnvelope(Envelope{
+		Version: EnvelopeVersion,
+		Command: command,
+		OK:      true,
+		Data:    data,
+	})
+}
+
+// PrintErrorEnvelope outputs a failed command result as JSON. data, if not
+// nil, carries what the command produced before failing.
+func PrintErrorEnvelope(command string, envErr EnvelopeError, data interface{}) error {
+	return printEnvelope(Envelope{
+		Version: EnvelopeVersion,
+		Command: command,
+		OK:      false,
+		Data:    data,
+		Error:   &envErr,
+	})
+}
+
+func printEnvelope(env Envelope) error {
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
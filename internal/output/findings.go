@@ -0,0 +1,275 @@
+package output
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/matroidbe/pgmigrate/internal/schema"
+)
+
+// Finding is a destructive or breaking change located in schema.yaml
+type Finding struct {
+	Change db.Change
+	Safety string // destructive or breaking
+	Level  string // error, warning or note
+	Line   int    // 0 if the change cannot be located
+}
+
+// PlanFindings turns the destructive and breaking changes of a plan into
+// findings, resolving each to the YAML line that caused it when possible
+func PlanFindings(plan *db.PlanResult, yamlContent []byte) []Finding {
+	loc, err := schema.NewLocator(yamlContent)
+	if err != nil {
+		loc = nil
+	}
+
+	var findings []Finding
+	for _, bucket := range []struct {
+		changes []db.Change
+		safety  string
+	}{
+		{plan.Breaking, "breaking"},
+		{plan.Destructive, "destructive"},
+	} {
+		for _, c := range bucket.changes {
+			safety := c.Safety
+			if safety == "" {
+				safety = bucket.safety
+			}
+			f := Finding{Change: c, Safety: safety, Level: severityLevel(safety)}
+			if loc != nil {
+				f.Line = locateChange(loc, c)
+			}
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// severityLevel maps a change's Safety to a SARIF level. Unknown values
+// are reported as warnings rather than hidden as notes.
+func severityLevel(safety string) string {
+	switch strings.ToLower(strings.TrimSpace(safety)) {
+	case "breaking":
+		return "error"
+	case "destructive":
+		return "warning"
+	case "safe":
+		return "note"
+	}
+	return "warning"
+}
+
+// locateChange returns the YAML line responsible for a change. Dropped
+// objects are no longer in the file, so they resolve to their table if it
+// is still defined.
+func locateChange(loc *schema.Locator, c db.Change) int {
+	switch c.Type {
+	case "create_schema", "drop_schema":
+		return loc.Schema(c.Name)
+	case "create_index", "drop_index":
+		name := c.GetIndexName()
+		if name == "" {
+			name = c.Name
+		}
+		return loc.Index(c.Schema, c.Table, name)
+	}
+	if col := c.GetColumnName(); col != "" {
+		return loc.Column(c.Schema, c.Table, col)
+	}
+	return loc.Table(c.Schema, c.Table)
+}
+
+// findingMessage describes a finding in one line
+func findingMessage(c db.Change) string {
+	if c.Description != "" {
+		return c.Description
+	}
+	return c.Key()
+}
+
+// findingRule returns the rule ID for a change
+func findingRule(c db.Change) string {
+	return "pgmigrate/" + c.Type
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           *sarifRegion  `json:"region,omitempty"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// PrintPlanSARIF outputs plan findings as a SARIF 2.1.0 log
+func PrintPlanSARIF(plan *db.PlanResult, schemaFile string, yamlContent []byte, toolVersion string) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "pgmigrate",
+			Version:        toolVersion,
+			InformationURI: "https://github.com/matroidbe/pgmigrate",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+
+	seenRules := map[string]bool{}
+	for _, f := range PlanFindings(plan, yamlContent) {
+		rule := findingRule(f.Change)
+		if !seenRules[rule] {
+			seenRules[rule] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+				ID:               rule,
+				ShortDescription: sarifMessage{Text: fmt.Sprintf("%s change (%s)", f.Change.Type, f.Safety)},
+			})
+		}
+
+		loc := sarifPhysicalLocation{ArtifactLocation: sarifArtifact{URI: schemaFile}}
+		if f.Line > 0 {
+			loc.Region = &sarifRegion{StartLine: f.Line}
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    rule,
+			Level:     f.Level,
+			Message:   sarifMessage{Text: findingMessage(f.Change)},
+			Locations: []sarifLocation{{PhysicalLocation: loc}},
+		})
+	}
+
+	data, err := json.MarshalIndent(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// PrintPlanJUnit outputs plan findings as a JUnit XML report, one failing
+// test case per destructive or breaking change
+func PrintPlanJUnit(plan *db.PlanResult, schemaFile string, yamlContent []byte) error {
+	suite := junitTestSuite{Name: "pgmigrate plan"}
+
+	for _, f := range PlanFindings(plan, yamlContent) {
+		className := f.Change.ObjectSchema()
+		if t := f.Change.ObjectTable(); t != "" {
+			className += "." + t
+		}
+
+		location := schemaFile
+		if f.Line > 0 {
+			location = fmt.Sprintf("%s:%d", schemaFile, f.Line)
+		}
+
+		suite.TestCases = append(suite.TestCases, junitTestCase{
+			Name:      f.Change.Key(),
+			ClassName: className,
+			File:      schemaFile,
+			Line:      f.Line,
+			Failure: &junitFailure{
+				Message: findingMessage(f.Change),
+				Type:    f.Level,
+				Text:    fmt.Sprintf("%s change at %s", f.Safety, location),
+			},
+		})
+	}
+	suite.Tests = len(suite.TestCases)
+	suite.Failures = len(suite.TestCases)
+
+	data, err := xml.MarshalIndent(junitTestSuites{
+		Name:     "pgmigrate",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Suites:   []junitTestSuite{suite},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(xml.Header)
+	fmt.Println(string(data))
+	return nil
+}
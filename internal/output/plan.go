@@ -2,7 +2,6 @@ package output
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -22,45 +21,63 @@ func PrintPlanTerraform(plan *db.PlanResult) {
 
 	// Safe changes (green +)
 	for _, change := range plan.Safe {
-		printChange(PlusSymbol, Green, change)
+		printChange("  ", PlusSymbol, Green, change)
 	}
 
 	// Destructive changes (red -)
 	for _, change := range plan.Destructive {
-		printChange(MinusSymbol, Red, change)
+		printChange("  ", MinusSymbol, Red, change)
 	}
 
 	// Breaking changes (yellow !)
 	for _, change := range plan.Breaking {
-		printChange(BangSymbol, Yellow, change)
+		printChange("  ", BangSymbol, Yellow, change)
 	}
 
 	fmt.Println()
+	printDataMigrations(plan)
 	printSummary(plan)
 }
 
-func printChange(symbol string, colorFn func(...interface{}) string, change db.Change) {
+// printDataMigrations lists the pending data migration scripts
+func printDataMigrations(plan *db.PlanResult) {
+	if len(plan.DataMigrations) == 0 {
+		return
+	}
+
+	fmt.Println(Bold("Pending data migrations:"))
+	for _, m := range plan.DataMigrations {
+		after := "after all changes"
+		if m.After != "" {
+			after = "after " + m.After
+		}
+		fmt.Printf("  %s %s %s\n", Cyan("~"), m.Name, Faint("("+after+")"))
+	}
+	fmt.Println()
+}
+
+func printChange(indent, symbol string, colorFn func(...interface{}) string, change db.Change) {
 	switch change.Type {
 	case "create_schema":
-		fmt.Printf("  %s %s\n", symbol, colorFn(fmt.Sprintf("CREATE SCHEMA %s", change.Name)))
+		fmt.Printf("%s%s %s\n", indent, symbol, colorFn(fmt.Sprintf("CREATE SCHEMA %s", change.Name)))
 
 	case "drop_schema":
-		fmt.Printf("  %s %s\n", symbol, colorFn(fmt.Sprintf("DROP SCHEMA %s", change.Name)))
+		fmt.Printf("%s%s %s\n", indent, symbol, colorFn(fmt.Sprintf("DROP SCHEMA %s", change.Name)))
 
 	case "create_table":
-		fmt.Printf("  %s %s\n", symbol, colorFn(fmt.Sprintf("CREATE TABLE %s.%s", change.Schema, change.Table)))
+		fmt.Printf("%s%s %s\n", indent, symbol, colorFn(fmt.Sprintf("CREATE TABLE %s.%s", change.Schema, change.Table)))
 
 	case "drop_table":
-		fmt.Printf("  %s %s\n", symbol, colorFn(fmt.Sprintf("DROP TABLE %s.%s", change.Schema, change.Table)))
+		fmt.Printf("%s%s %s\n", indent, symbol, colorFn(fmt.Sprintf("DROP TABLE %s.%s", change.Schema, change.Table)))
 
 	case "add_column":
 		colName := change.GetColumnName()
-		fmt.Printf("  %s %s.%s.%s %s\n", symbol,
+		fmt.Printf("%s%s %s.%s.%s %s\n", indent, symbol,
 			change.Schema, change.Table, colorFn(colName), Faint("(add column)"))
 
 	case "drop_column":
 		colName := change.GetColumnName()
-		fmt.Printf("  %s %s.%s.%s %s\n", symbol,
+		fmt.Printf("%s%s %s.%s.%s %s\n", indent, symbol,
 			change.Schema, change.Table, colorFn(colName), Faint("(drop column)"))
 
 	case "alter_column_type":
@@ -69,34 +86,42 @@ func printChange(symbol string, colorFn func(...interface{}) string, change db.C
 		if change.OldType != nil && change.NewType != nil {
 			typeInfo = fmt.Sprintf(" %s -> %s", *change.OldType, *change.NewType)
 		}
-		fmt.Printf("  %s %s.%s.%s %s%s\n", symbol,
+		fmt.Printf("%s%s %s.%s.%s %s%s\n", indent, symbol,
 			change.Schema, change.Table, colorFn(colName), Faint("(alter type)"), typeInfo)
 
 	case "alter_column_nullable":
 		colName := change.GetColumnName()
-		fmt.Printf("  %s %s.%s.%s %s\n", symbol,
+		fmt.Printf("%s%s %s.%s.%s %s\n", indent, symbol,
 			change.Schema, change.Table, colorFn(colName), Faint("(alter nullable)"))
 
 	case "alter_column_default":
 		colName := change.GetColumnName()
-		fmt.Printf("  %s %s.%s.%s %s\n", symbol,
+		fmt.Printf("%s%s %s.%s.%s %s\n", indent, symbol,
 			change.Schema, change.Table, colorFn(colName), Faint("(alter default)"))
 
 	case "create_index":
 		indexName := change.GetIndexName()
-		fmt.Printf("  %s %s %s\n", symbol, colorFn(fmt.Sprintf("CREATE INDEX %s", indexName)), Faint(fmt.Sprintf("ON %s.%s", change.Schema, change.Table)))
+		fmt.Printf("%s%s %s %s\n", indent, symbol, colorFn(fmt.Sprintf("CREATE INDEX %s", indexName)), Faint(fmt.Sprintf("ON %s.%s", change.Schema, change.Table)))
 
 	case "drop_index":
-		fmt.Printf("  %s %s %s\n", symbol, colorFn(fmt.Sprintf("DROP INDEX %s.%s", change.Schema, change.Name)), Faint(""))
+		fmt.Printf("%s%s %s %s\n", indent, symbol, colorFn(fmt.Sprintf("DROP INDEX %s.%s", change.Schema, change.Name)), Faint(""))
 
 	default:
 		// Fallback for unknown change types
 		if change.Description != "" {
-			fmt.Printf("  %s %s\n", symbol, colorFn(change.Description))
+			fmt.Printf("%s%s %s\n", indent, symbol, colorFn(change.Description))
 		} else {
-			fmt.Printf("  %s %s\n", symbol, colorFn(change.Type))
+			fmt.Printf("%s%s %s\n", indent, symbol, colorFn(change.Type))
 		}
 	}
+
+	switch {
+	case change.Concurrently:
+		fmt.Printf("%s    %s\n", indent, Faint("runs CONCURRENTLY outside the transaction"))
+	case len(change.Steps) > 0:
+		fmt.Printf("%s    %s\n", indent,
+			Faint(fmt.Sprintf("runs in %d steps outside the transaction", len(change.Steps))))
+	}
 }
 
 func printSummary(plan *db.PlanResult) {
@@ -111,32 +136,28 @@ func printSummary(plan *db.PlanResult) {
 	if plan.BreakingCount() > 0 {
 		parts = append(parts, Yellow(fmt.Sprintf("%d breaking", plan.BreakingCount())))
 	}
+	if n := len(plan.DataMigrations); n > 0 {
+		parts = append(parts, Cyan(fmt.Sprintf("%d data migration(s)", n)))
+	}
 
 	fmt.Printf("Plan: %s.\n", strings.Join(parts, ", "))
 
 	if plan.BreakingCount() > 0 {
 		fmt.Println()
 		fmt.Println(Yellow("Warning:") + " Breaking changes require manual intervention.")
-		fmt.Println("Run SQL directly or use pgmigrate.dba_migrate() in psql.")
+		fmt.Println("Use 'pgmigrate apply --allow-breaking --reason ...' or 'pgmigrate dba'.")
 	}
 }
 
-// PrintPlanJSON outputs the plan as JSON
-func PrintPlanJSON(plan *db.PlanResult) error {
-	data, err := json.MarshalIndent(plan, "", "  ")
-	if err != nil {
-		return err
-	}
-	fmt.Println(string(data))
-	return nil
-}
+// stdin is shared by all prompts, so input buffered by one prompt is not
+// lost to the next
+var stdin = bufio.NewReader(os.Stdin)
 
 // ConfirmPrompt asks user for confirmation
 func ConfirmPrompt(message string) bool {
 	fmt.Printf("%s [y/N]: ", message)
 
-	reader := bufio.NewReader(os.Stdin)
-	response, err := reader.ReadString('\n')
+	response, err := stdin.ReadString('\n')
 	if err != nil {
 		return false
 	}
@@ -145,16 +166,77 @@ func ConfirmPrompt(message string) bool {
 	return response == "y" || response == "yes"
 }
 
+// TypedConfirmPrompt asks the user to type expected to confirm
+func TypedConfirmPrompt(message, expected string) bool {
+	fmt.Printf("%s\nType %s to confirm: ", message, Bold(expected))
+
+	response, err := stdin.ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(response) == expected
+}
+
+// ChoicePrompt asks until the answer is the first letter of one of the
+// choices, and returns that letter. End of input answers the last choice.
+func ChoicePrompt(message string, choices []string) string {
+	letters := make([]string, len(choices))
+	for i, c := range choices {
+		letters[i] = c[:1]
+	}
+
+	for {
+		fmt.Printf("%s [%s]: ", message, strings.Join(letters, "/"))
+		response, err := stdin.ReadString('\n')
+		if err != nil && response == "" {
+			fmt.Println()
+			return letters[len(letters)-1]
+		}
+
+		response = strings.ToLower(strings.TrimSpace(response))
+		for i, c := range choices {
+			if response == letters[i] || response == c {
+				return letters[i]
+			}
+		}
+		fmt.Printf("Answer one of: %s\n", strings.Join(choices, ", "))
+	}
+}
+
+// PrintChangeSQL shows the SQL that will run for each change
+func PrintChangeSQL(changes []db.Change) error {
+	for _, change := range changes {
+		stmts, err := db.ChangeSQL(change)
+		if err != nil {
+			return err
+		}
+		fmt.Println(Faint("-- " + change.Key()))
+		for _, stmt := range stmts {
+			fmt.Println(Yellow(stmt + ";"))
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
 // PrintApplyResult shows the result of apply
 func PrintApplyResult(result *db.ApplyResult) {
-	if len(result.Applied) == 0 {
+	if len(result.Applied) == 0 && len(result.DataMigrations) == 0 {
 		fmt.Println(Yellow("No changes applied."))
 		return
 	}
 
 	fmt.Println()
-	fmt.Printf("%s Applied %d change(s) in %dms.\n",
-		Green("Apply complete!"), len(result.Applied), result.DurationMs)
+	fmt.Printf("%s Applied %d change(s)", Green("Apply complete!"), len(result.Applied))
+	if n := len(result.DataMigrations); n > 0 {
+		fmt.Printf(" and %d data migration(s)", n)
+	}
+	fmt.Printf(" in %dms", result.DurationMs)
+	if result.Attempts > 1 {
+		fmt.Printf(" after %d attempts", result.Attempts)
+	}
+	fmt.Println(".")
 
 	if len(result.Skipped) > 0 {
 		fmt.Printf("%s Use --allow-destructive to include.\n",
@@ -162,6 +244,59 @@ func PrintApplyResult(result *db.ApplyResult) {
 	}
 }
 
+// PrintCommitted lists what a failed or interrupted apply committed before
+// it stopped
+func PrintCommitted(result *db.ApplyResult) {
+	committed := len(result.Applied) + len(result.DataMigrations)
+	if committed == 0 {
+		fmt.Println(Yellow("No changes were committed."))
+		return
+	}
+
+	fmt.Println(Yellow(fmt.Sprintf("%d change(s) were committed before the apply stopped:", committed)))
+	for _, c := range result.Applied {
+		fmt.Printf("  %s\n", c.Key())
+	}
+	for _, name := range result.DataMigrations {
+		fmt.Printf("  data migration %s\n", name)
+	}
+}
+
+// PrintDryRunResult shows how each statement of a dry run went
+func PrintDryRunResult(result *db.DryRunResult) {
+	fmt.Println()
+	fmt.Println(Bold("Dry run:"))
+	ok, skipped := 0, 0
+	for _, st := range result.Statements {
+		status := Green("ok     ")
+		switch st.Status {
+		case db.DryRunFailed:
+			status = Red("failed ")
+		case db.DryRunSkipped:
+			status = Yellow("skipped")
+			skipped++
+		default:
+			ok++
+		}
+		label := summarizeSQL(st.SQL)
+		if st.Script != "" {
+			label = "data migration " + st.Script
+		}
+		fmt.Printf("  %s %s %s\n", status, label, Faint(formatElapsed(st.DurationMs)))
+		if st.Error != "" {
+			fmt.Printf("          %s\n", Faint(st.Error))
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("%d succeeded, %d failed, %d skipped in %dms. Everything was rolled back.\n",
+		ok, result.Failed, skipped, result.DurationMs)
+	if len(result.Skipped) > 0 {
+		fmt.Printf("%s Use --allow-destructive to include.\n",
+			Yellow(fmt.Sprintf("Left out %d destructive change(s).", len(result.Skipped))))
+	}
+}
+
 // PrintError prints an error message in red
 func PrintError(message string) {
 	fmt.Println(Red("Error:") + " " + message)
@@ -215,3 +350,27 @@ func PrintHistoryTable(entries []db.HistoryEntry) {
 			e.DurationMs)
 	}
 }
+
+// PrintArchiveTable prints archived tables and columns in a table format
+func PrintArchiveTable(archives []db.Archive) {
+	if len(archives) == 0 {
+		fmt.Println("No archives found.")
+		return
+	}
+
+	fmt.Println(Bold("Archives"))
+	fmt.Println()
+	fmt.Printf("%-6s %-8s %-36s %-20s %s\n", "ID", "KIND", "OBJECT", "ARCHIVED AT", "BY")
+	fmt.Println(strings.Repeat("-", 90))
+
+	for _, a := range archives {
+		fmt.Printf("%-6d %-8s %-36s %-20s %s\n",
+			a.ID, a.Kind, a.Object(), a.ArchivedAt.Format("2006-01-02 15:04:05"), a.ArchivedBy)
+		fmt.Printf("%-6s %s\n", "", Faint(ArchiveLocation(a)))
+	}
+}
+
+// ArchiveLocation returns the qualified name of an archive table
+func ArchiveLocation(a db.Archive) string {
+	return db.ArchiveSchema + "." + a.ArchiveTable
+}
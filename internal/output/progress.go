@@ -0,0 +1,140 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matroidbe/pgmigrate/internal/db"
+	"github.com/mattn/go-isatty"
+)
+
+// Progress prints a live "applying 3/17: ..." line while changes run. On a
+// terminal the line is redrawn with the elapsed time; otherwise one line is
+// printed per finished change.
+type Progress struct {
+	mu       sync.Mutex
+	tty      bool
+	label    string
+	phase    string
+	started  time.Time
+	stopTick chan struct{}
+}
+
+// NewProgress creates a progress printer for stdout
+func NewProgress() *Progress {
+	return &Progress{tty: isatty.IsTerminal(os.Stdout.Fd())}
+}
+
+// Handle consumes an apply event
+func (p *Progress) Handle(ev db.Event) {
+	switch ev.Type {
+	case db.EventChangeStarted:
+		p.mu.Lock()
+		if ev.Phase == db.ApplyPhaseOnline && p.phase != ev.Phase {
+			fmt.Println(Bold("Outside the transaction, each statement committed on its own:"))
+		}
+		p.phase = ev.Phase
+		p.label = fmt.Sprintf("applying %d/%d: %s", ev.Index, ev.Total, summarizeSQL(ev.SQL))
+		if ev.Script != "" {
+			p.label = fmt.Sprintf("applying %d/%d: data migration %s", ev.Index, ev.Total, ev.Script)
+		}
+		p.started = ev.Time
+		p.mu.Unlock()
+		if p.tty {
+			p.redraw()
+			p.startTicker()
+		}
+
+	case db.EventRetry:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		fmt.Printf("  %s %s\n", Yellow(fmt.Sprintf("Attempt %d failed, retrying in %s:", ev.Attempt,
+			time.Duration(ev.BackoffMs)*time.Millisecond)), ev.Error)
+
+	case db.EventChangeFinished, db.EventChangeFailed:
+		p.stopTicker()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		status := Green("done")
+		if ev.Type == db.EventChangeFailed {
+			status = Red("failed")
+		}
+		line := fmt.Sprintf("  %s %s %s", p.label, Faint(formatElapsed(ev.ElapsedMs)), status)
+		if p.tty {
+			fmt.Printf("\r\033[K%s\n", line)
+		} else {
+			fmt.Println(line)
+		}
+	}
+}
+
+func (p *Progress) redraw() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	elapsed := time.Since(p.started).Milliseconds()
+	fmt.Printf("\r\033[K  %s %s", p.label, Faint(formatElapsed(elapsed)))
+}
+
+func (p *Progress) startTicker() {
+	stop := make(chan struct{})
+	p.stopTick = stop
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.redraw()
+			}
+		}
+	}()
+}
+
+func (p *Progress) stopTicker() {
+	if p.stopTick != nil {
+		close(p.stopTick)
+		p.stopTick = nil
+	}
+}
+
+// summarizeSQL shortens SQL to its first line for progress output
+func summarizeSQL(sql string) string {
+	const max = 60
+	line, _, more := strings.Cut(sql, "\n")
+	if len(line) > max {
+		return line[:max] + "…"
+	}
+	if more {
+		return line + " …"
+	}
+	return line
+}
+
+func formatElapsed(ms int64) string {
+	return fmt.Sprintf("(%.1fs)", float64(ms)/1000)
+}
+
+// EventWriter writes apply events as newline-delimited JSON
+type EventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewEventWriter creates an NDJSON event writer
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{enc: json.NewEncoder(w)}
+}
+
+// Handle writes one event per line
+func (e *EventWriter) Handle(ev db.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(ev)
+}
@@ -0,0 +1,142 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matroidbe/pgmigrate/internal/db"
+)
+
+// TreeThreshold is the number of changes above which plans are grouped by
+// schema and table unless a flat list is requested
+const TreeThreshold = 30
+
+// PrintPlan outputs a plan, grouping it by schema and table when it is large
+func PrintPlan(plan *db.PlanResult, flat bool) {
+	if flat || plan.Len() <= TreeThreshold {
+		PrintPlanTerraform(plan)
+		return
+	}
+	PrintPlanTree(plan)
+}
+
+// planEntry is a change with its safety bucket
+type planEntry struct {
+	change  db.Change
+	symbol  string
+	colorFn func(...interface{}) string
+	safety  string
+}
+
+// tableGroup collects the changes of one table in execution order
+type tableGroup struct {
+	name    string
+	entries []planEntry
+}
+
+// schemaGroup collects schema-level changes and tables of one schema
+type schemaGroup struct {
+	name    string
+	entries []planEntry
+	tables  []*tableGroup
+	byName  map[string]*tableGroup
+}
+
+// PrintPlanTree outputs the plan grouped as schema -> table -> changes, with
+// per-table safety counts. Changes within a table follow execution order.
+func PrintPlanTree(plan *db.PlanResult) {
+	if plan.IsEmpty() {
+		PrintPlanTerraform(plan)
+		return
+	}
+
+	fmt.Println(Bold("pgmigrate will perform the following actions:"))
+	fmt.Println()
+
+	for _, sg := range groupPlan(plan) {
+		fmt.Printf("  %s %s\n", Bold(sg.name), countsLabel(sg.allEntries()))
+		for _, e := range sg.entries {
+			printChange("    ", e.symbol, e.colorFn, e.change)
+		}
+		for _, tg := range sg.tables {
+			fmt.Printf("    %s %s\n", Cyan(tg.name), countsLabel(tg.entries))
+			for _, e := range tg.entries {
+				printChange("      ", e.symbol, e.colorFn, e.change)
+			}
+		}
+		fmt.Println()
+	}
+
+	printDataMigrations(plan)
+	printSummary(plan)
+}
+
+// groupPlan groups changes by schema and table, keeping first-seen order
+func groupPlan(plan *db.PlanResult) []*schemaGroup {
+	var groups []*schemaGroup
+	bySchema := map[string]*schemaGroup{}
+
+	safeEnd := plan.SafeCount()
+	destructiveEnd := safeEnd + plan.DestructiveCount()
+
+	for i, change := range plan.ExecutionOrder() {
+		e := planEntry{change: change, symbol: PlusSymbol, colorFn: Green, safety: "safe"}
+		switch {
+		case i >= destructiveEnd:
+			e.symbol, e.colorFn, e.safety = BangSymbol, Yellow, "breaking"
+		case i >= safeEnd:
+			e.symbol, e.colorFn, e.safety = MinusSymbol, Red, "destructive"
+		}
+
+		schemaName := change.ObjectSchema()
+		sg, ok := bySchema[schemaName]
+		if !ok {
+			sg = &schemaGroup{name: schemaName, byName: map[string]*tableGroup{}}
+			bySchema[schemaName] = sg
+			groups = append(groups, sg)
+		}
+
+		tableName := change.ObjectTable()
+		if tableName == "" {
+			sg.entries = append(sg.entries, e)
+			continue
+		}
+		tg, ok := sg.byName[tableName]
+		if !ok {
+			tg = &tableGroup{name: tableName}
+			sg.byName[tableName] = tg
+			sg.tables = append(sg.tables, tg)
+		}
+		tg.entries = append(tg.entries, e)
+	}
+
+	return groups
+}
+
+func (sg *schemaGroup) allEntries() []planEntry {
+	all := append([]planEntry{}, sg.entries...)
+	for _, tg := range sg.tables {
+		all = append(all, tg.entries...)
+	}
+	return all
+}
+
+// countsLabel formats per-safety counts such as "(2 safe, 1 destructive)"
+func countsLabel(entries []planEntry) string {
+	counts := map[string]int{}
+	for _, e := range entries {
+		counts[e.safety]++
+	}
+
+	parts := []string{}
+	if n := counts["safe"]; n > 0 {
+		parts = append(parts, Green(fmt.Sprintf("%d safe", n)))
+	}
+	if n := counts["destructive"]; n > 0 {
+		parts = append(parts, Red(fmt.Sprintf("%d destructive", n)))
+	}
+	if n := counts["breaking"]; n > 0 {
+		parts = append(parts, Yellow(fmt.Sprintf("%d breaking", n)))
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
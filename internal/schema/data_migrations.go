@@ -0,0 +1,125 @@
+package schema
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// DataMigrationsDir is the directory, next to schema.yaml, holding data
+// migration scripts
+const DataMigrationsDir = "data_migrations"
+
+// DataMigration is a one-off SQL script run during apply. Files ending in
+// .sql.tmpl are Go templates.
+type DataMigration struct {
+	Name     string
+	After    string // key of the change it must follow, from "-- after: ..."
+	Template bool
+	Body     string
+}
+
+// LoadDataMigrations reads the scripts in dir, ordered by file name. A
+// missing directory has no scripts.
+func LoadDataMigrations(dir string) ([]DataMigration, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", dir, err)
+	}
+
+	var migrations []DataMigration
+	for _, e := range entries {
+		name := e.Name()
+		isTemplate := strings.HasSuffix(name, ".sql.tmpl")
+		if e.IsDir() || (!isTemplate && !strings.HasSuffix(name, ".sql")) {
+			continue
+		}
+
+		body, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %w", name, err)
+		}
+		after, err := afterDirective(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		migrations = append(migrations, DataMigration{
+			Name:     name,
+			After:    after,
+			Template: isTemplate,
+			Body:     string(body),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Name < migrations[j].Name })
+	return migrations, nil
+}
+
+// Render returns the SQL of the script, executing it as a template with
+// data if it is one
+func (m DataMigration) Render(data interface{}) (string, error) {
+	if !m.Template {
+		return m.Body, nil
+	}
+
+	tmpl, err := template.New(m.Name).Option("missingkey=error").Parse(m.Body)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", m.Name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("%s: %w", m.Name, err)
+	}
+	return b.String(), nil
+}
+
+// afterKeyParts is the number of dot-separated parts of the object in a
+// change key, by change type
+var afterKeyParts = map[string]int{
+	"create_schema":         1,
+	"drop_schema":           1,
+	"create_table":          2,
+	"drop_table":            2,
+	"add_column":            3,
+	"drop_column":           3,
+	"alter_column_type":     3,
+	"alter_column_nullable": 3,
+	"alter_column_default":  3,
+	"create_index":          2,
+	"drop_index":            2,
+}
+
+// afterDirective returns the value of a "-- after:" line in the leading
+// comment block of a script. A value that is not a change key is an error,
+// since the script would otherwise silently run after all changes.
+func afterDirective(body string) (string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		comment, ok := strings.CutPrefix(line, "--")
+		if !ok {
+			break
+		}
+		if value, ok := strings.CutPrefix(strings.TrimSpace(comment), "after:"); ok {
+			key := strings.TrimSpace(value)
+			changeType, object, _ := strings.Cut(key, " ")
+			parts, known := afterKeyParts[changeType]
+			if !known || object == "" || len(strings.Split(object, ".")) != parts {
+				return "", fmt.Errorf("unknown change %q in -- after:; use <type> <schema>.<table>[.<column>], as in dependency errors", key)
+			}
+			return key, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,38 @@
+package schema
+
+import "testing"
+
+func TestAfterDirective(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+		wantErr          bool
+	}{
+		{name: "none", body: "UPDATE users SET slug = name;"},
+		{name: "column", body: "-- after: add_column public.users.slug\nUPDATE users SET slug = name;",
+			want: "add_column public.users.slug"},
+		{name: "index", body: "-- backfill\n-- after: create_index public.users_slug_idx\nSELECT 1;",
+			want: "create_index public.users_slug_idx"},
+		{name: "after the leading comments", body: "SELECT 1;\n-- after: add_column public.users.slug"},
+		{name: "unknown type", body: "-- after: add_colum public.users.slug\nSELECT 1;", wantErr: true},
+		{name: "missing column", body: "-- after: add_column public.users\nSELECT 1;", wantErr: true},
+		{name: "missing object", body: "-- after: create_table\nSELECT 1;", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := afterDirective(tt.body)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("accepted %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Locator maps schema objects to the line that defines them in schema.yaml
+type Locator struct {
+	tables  map[string]int
+	columns map[string]int
+	indexes map[string]int
+	schemas map[string]int
+}
+
+// NewLocator parses YAML content and records the position of every
+// managed schema, table, column and index
+func NewLocator(content []byte) (*Locator, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("cannot parse schema: %w", err)
+	}
+
+	l := &Locator{
+		tables:  map[string]int{},
+		columns: map[string]int{},
+		indexes: map[string]int{},
+		schemas: map[string]int{},
+	}
+	if len(doc.Content) == 0 {
+		return l, nil
+	}
+
+	root := doc.Content[0]
+	if schemas := mappingValue(root, "managed_schemas"); schemas != nil {
+		for _, s := range schemas.Content {
+			l.schemas[s.Value] = s.Line
+		}
+	}
+
+	tables := mappingValue(root, "tables")
+	if tables == nil || tables.Kind != yaml.MappingNode {
+		return l, nil
+	}
+	for i := 0; i+1 < len(tables.Content); i += 2 {
+		key, table := tables.Content[i], tables.Content[i+1]
+		qualified := qualify(key.Value)
+		l.tables[qualified] = key.Line
+
+		if cols := mappingValue(table, "columns"); cols != nil {
+			for _, col := range cols.Content {
+				if name := mappingValue(col, "name"); name != nil {
+					l.columns[qualified+"."+name.Value] = col.Line
+				}
+			}
+		}
+		if idxs := mappingValue(table, "indexes"); idxs != nil {
+			for _, idx := range idxs.Content {
+				if name := mappingValue(idx, "name"); name != nil {
+					l.indexes[schemaOf(qualified)+"."+name.Value] = idx.Line
+				}
+			}
+		}
+	}
+
+	return l, nil
+}
+
+// Schema returns the line of a managed schema entry, or 0 if not found
+func (l *Locator) Schema(name string) int {
+	return l.schemas[name]
+}
+
+// Table returns the line of a table definition, or 0 if not found
+func (l *Locator) Table(schema, table string) int {
+	return l.tables[schema+"."+table]
+}
+
+// Column returns the line of a column definition, falling back to its table
+func (l *Locator) Column(schema, table, column string) int {
+	if line, ok := l.columns[schema+"."+table+"."+column]; ok {
+		return line
+	}
+	return l.Table(schema, table)
+}
+
+// Index returns the line of an index definition, falling back to its table
+func (l *Locator) Index(schema, table, index string) int {
+	if line, ok := l.indexes[schema+"."+index]; ok {
+		return line
+	}
+	return l.Table(schema, table)
+}
+
+// mappingValue returns the value node for key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// schemaOf returns the schema part of a "schema.table" key
+func schemaOf(qualified string) string {
+	if schema, _, ok := strings.Cut(qualified, "."); ok {
+		return schema
+	}
+	return "public"
+}
+
+// qualify adds the default schema to an unqualified table key
+func qualify(table string) string {
+	if strings.Contains(table, ".") {
+		return table
+	}
+	return "public." + table
+}
@@ -0,0 +1,48 @@
+package schema
+
+import "testing"
+
+const locatorSchema = `managed_schemas:
+  - public
+  - app
+tables:
+  users:
+    columns:
+      - name: id
+        type: bigint
+      - name: email
+        type: text
+    indexes:
+      - name: users_email_idx
+        columns: [email]
+  app.orders:
+    columns:
+      - name: id
+        type: bigint
+`
+
+func TestLocatorResolvesQualifiedAndUnqualifiedTables(t *testing.T) {
+	l, err := NewLocator([]byte(locatorSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  int
+		want int
+	}{
+		{"unqualified table", l.Table("public", "users"), 5},
+		{"unqualified column", l.Column("public", "users", "email"), 9},
+		{"unqualified index", l.Index("public", "users", "users_email_idx"), 12},
+		{"qualified table", l.Table("app", "orders"), 14},
+		{"qualified column", l.Column("app", "orders", "id"), 16},
+		{"missing column falls back to table", l.Column("public", "users", "name"), 5},
+		{"unknown table", l.Table("public", "orders"), 0},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got line %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
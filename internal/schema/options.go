@@ -0,0 +1,132 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Options are settings in schema.yaml that only pgmigrate reads. pg_migrate
+// does not know them, so they are removed before the schema is loaded.
+type Options struct {
+	// ConcurrentIndexes holds "schema.index" for every index marked
+	// concurrently: true
+	ConcurrentIndexes map[string]bool
+
+	// Protected holds "schema.table" and "schema.table.column" for every
+	// table and column with a protected key, mapped to its value
+	Protected map[string]bool
+
+	// TenantTemplate is the schema stamped onto tenant schemas by
+	// --tenants, from the top-level tenant_template key
+	TenantTemplate string
+}
+
+// ExtractOptions reads the pgmigrate-only keys from YAML content. It returns
+// the options and the content with those keys removed. Content without such
+// keys is returned unchanged.
+func ExtractOptions(content []byte) (*Options, []byte, error) {
+	opts := &Options{ConcurrentIndexes: map[string]bool{}, Protected: map[string]bool{}}
+
+	var doc yaml.Node
+	var err error
+	if err = yaml.Unmarshal(content, &doc); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse schema: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return opts, content, nil
+	}
+
+	stripped := false
+	if value, ok := removeKey(doc.Content[0], TenantTemplateKey); ok {
+		stripped = true
+		if value.Kind != yaml.ScalarNode || value.Value == "" {
+			return nil, nil, fmt.Errorf("line %d: %s must be a schema name", value.Line, TenantTemplateKey)
+		}
+		opts.TenantTemplate = value.Value
+	}
+
+	tables := mappingValue(doc.Content[0], "tables")
+	if tables != nil && tables.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(tables.Content); i += 2 {
+			key, table := tables.Content[i], tables.Content[i+1]
+
+			if value, ok := removeKey(table, "protected"); ok {
+				stripped = true
+				if opts.Protected[qualify(key.Value)], err = decodeBool(value, "protected"); err != nil {
+					return nil, nil, err
+				}
+			}
+
+			if cols := mappingValue(table, "columns"); cols != nil {
+				for _, col := range cols.Content {
+					value, ok := removeKey(col, "protected")
+					if !ok {
+						continue
+					}
+					stripped = true
+					name := mappingValue(col, "name")
+					if name == nil {
+						continue
+					}
+					if opts.Protected[qualify(key.Value)+"."+name.Value], err = decodeBool(value, "protected"); err != nil {
+						return nil, nil, err
+					}
+				}
+			}
+
+			if idxs := mappingValue(table, "indexes"); idxs != nil {
+				for _, idx := range idxs.Content {
+					value, ok := removeKey(idx, "concurrently")
+					if !ok {
+						continue
+					}
+					stripped = true
+					on, err := decodeBool(value, "concurrently")
+					if err != nil {
+						return nil, nil, err
+					}
+					if name := mappingValue(idx, "name"); name != nil && on {
+						opts.ConcurrentIndexes[schemaOf(key.Value)+"."+name.Value] = true
+					}
+				}
+			}
+		}
+	}
+
+	if !stripped {
+		return opts, content, nil
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("cannot encode schema: %w", err)
+	}
+	return opts, buf.Bytes(), nil
+}
+
+func decodeBool(node *yaml.Node, key string) (bool, error) {
+	var b bool
+	if err := node.Decode(&b); err != nil {
+		return false, fmt.Errorf("line %d: %s must be true or false", node.Line, key)
+	}
+	return b, nil
+}
+
+// removeKey deletes key from a mapping node and returns its value
+func removeKey(node *yaml.Node, key string) (*yaml.Node, bool) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			value := node.Content[i+1]
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,78 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TenantTemplateKey is the top-level schema.yaml key naming the template
+// schema that is stamped onto every tenant schema
+const TenantTemplateKey = "tenant_template"
+
+// StampTenant rewrites schema.yaml content for one tenant: only the tables
+// of the template schema are kept, moved to the tenant schema, and the
+// template is the only managed schema. Foreign keys into the template
+// schema point into the tenant schema; foreign keys to shared schemas are
+// left alone.
+func StampTenant(content []byte, template, tenant string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("cannot parse schema: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("schema is empty")
+	}
+	root := doc.Content[0]
+
+	removeKey(root, TenantTemplateKey)
+
+	managed := &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: tenant},
+	}}
+	if value := mappingValue(root, "managed_schemas"); value != nil {
+		*value = *managed
+	} else {
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: "managed_schemas"}, managed)
+	}
+
+	tables := mappingValue(root, "tables")
+	if tables == nil || tables.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("schema has no tables in template schema %s", template)
+	}
+
+	var kept []*yaml.Node
+	for i := 0; i+1 < len(tables.Content); i += 2 {
+		key, table := tables.Content[i], tables.Content[i+1]
+		if schemaOf(qualify(key.Value)) != template {
+			continue
+		}
+		_, name, _ := strings.Cut(qualify(key.Value), ".")
+		key.Value = tenant + "." + name
+
+		if cols := mappingValue(table, "columns"); cols != nil {
+			for _, col := range cols.Content {
+				ref := mappingValue(col, "references")
+				if ref != nil && strings.HasPrefix(ref.Value, template+".") {
+					ref.Value = tenant + strings.TrimPrefix(ref.Value, template)
+				}
+			}
+		}
+		kept = append(kept, key, table)
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("schema has no tables in template schema %s", template)
+	}
+	tables.Content = kept
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("cannot encode schema: %w", err)
+	}
+	return buf.Bytes(), nil
+}